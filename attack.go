@@ -0,0 +1,246 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// AttackOptions describe an attack roll and the damage it does on a hit
+type AttackOptions struct {
+	Bonus        int         // Added to the d20
+	Damage       DiceFormula // Rolled on a hit, with the dice doubled on a critical hit
+	DamageType   string      // Such as "slashing", reported with the damage
+	Ranged       bool        // Prone targets are harder to hit from range and easier up close
+	Advantage    bool        // Given by the GM on top of what conditions give
+	Disadvantage bool
+}
+
+// attackerEffects are the conditions that give the attacker advantage (true) or disadvantage
+var attackerEffects = map[string]bool{
+	"Blinded":    false,
+	"Frightened": false,
+	"Invisible":  true,
+	"Poisoned":   false,
+	"Prone":      false,
+	"Restrained": false,
+}
+
+// targetEffects are the conditions on the target that give the attacker advantage (true) or disadvantage
+var targetEffects = map[string]bool{
+	"Blinded":     true,
+	"Invisible":   false,
+	"Paralyzed":   true,
+	"Petrified":   true,
+	"Restrained":  true,
+	"Stunned":     true,
+	"Unconscious": true,
+}
+
+// hasEffect reports whether the combatant suffers a status effect, ignoring
+// case. A combatant knocked out at 0 HP counts as Unconscious.
+func (c *Combatant) hasEffect(effect string) bool {
+	if strings.EqualFold(effect, "Unconscious") && !c.IsConscious {
+		return true
+	}
+	for _, e := range c.StatusEffects {
+		if strings.EqualFold(e, effect) {
+			return true
+		}
+	}
+	return false
+}
+
+// attackModes works out advantage and disadvantage from the conditions on
+// both sides, with the reasons for each
+func attackModes(attacker, target *Combatant, ranged bool) (advantage, disadvantage []string) {
+	for _, effect := range defaultStatusEffects() {
+		if gives, ok := attackerEffects[effect]; ok && attacker.hasEffect(effect) {
+			reason := fmt.Sprintf("%s is %s", attacker.Name, effect)
+			if gives {
+				advantage = append(advantage, reason)
+			} else {
+				disadvantage = append(disadvantage, reason)
+			}
+		}
+
+		gives, ok := targetEffects[effect]
+		if effect == "Prone" {
+			gives, ok = !ranged, true
+		}
+		if ok && target.hasEffect(effect) {
+			reason := fmt.Sprintf("%s is %s", target.Name, effect)
+			if gives {
+				advantage = append(advantage, reason)
+			} else {
+				disadvantage = append(disadvantage, reason)
+			}
+		}
+	}
+	return advantage, disadvantage
+}
+
+// doubleDice returns the formula with twice as many dice, for a critical hit
+func doubleDice(f DiceFormula) DiceFormula {
+	doubled := DiceFormula{Modifier: f.Modifier}
+	for _, d := range f.Dice {
+		doubled.Dice = append(doubled.Dice, Dice{Count: d.Count * 2, Sides: d.Sides})
+	}
+	return doubled
+}
+
+// Attack rolls an attack against the target's AC and applies the damage on a
+// hit. Conditions on either side give advantage or disadvantage, a natural 20
+// is a critical hit and a natural 1 always misses. The damage counts towards
+// the attacker's statistics whoever has the turn.
+func (ct *CombatTracker) Attack(attacker, target int, opts AttackOptions) {
+	if attacker < 0 || attacker >= len(ct.Combatants) || target < 0 || target >= len(ct.Combatants) {
+		ct.println("Invalid combatant index!")
+		return
+	}
+
+	a, t := &ct.Combatants[attacker], &ct.Combatants[target]
+	if t.AC <= 0 {
+		ct.printf("%s has no AC, set it with ac <target> <AC> first.\n", t.Name)
+		return
+	}
+
+	advantage, disadvantage := attackModes(a, t, opts.Ranged)
+	if opts.Advantage {
+		advantage = append(advantage, "given by the GM")
+	}
+	if opts.Disadvantage {
+		disadvantage = append(disadvantage, "given by the GM")
+	}
+
+	d20 := rollDie(20)
+	rolled := strconv.Itoa(d20)
+	switch {
+	case len(advantage) > 0 && len(disadvantage) > 0:
+		ct.printf("Advantage (%s) and disadvantage (%s) cancel out.\n", strings.Join(advantage, ", "), strings.Join(disadvantage, ", "))
+	case len(advantage) > 0:
+		second := rollDie(20)
+		rolled = fmt.Sprintf("%d and %d with advantage (%s)", d20, second, strings.Join(advantage, ", "))
+		d20 = max(d20, second)
+	case len(disadvantage) > 0:
+		second := rollDie(20)
+		rolled = fmt.Sprintf("%d and %d with disadvantage (%s)", d20, second, strings.Join(disadvantage, ", "))
+		d20 = min(d20, second)
+	}
+
+	total := d20 + opts.Bonus
+	ct.printf("%s attacks %s: rolled %s, %d%+d = %d against AC %d\n", a.Name, t.Name, rolled, d20, opts.Bonus, total, t.AC)
+
+	critical := d20 == 20
+	if !critical && (d20 == 1 || total < t.AC) {
+		ct.logf("%s misses %s.", a.Name, t.Name)
+
+		// Auto-save state
+		ct.AutoSave()
+		return
+	}
+
+	// Any hit from up close on a paralyzed or unconscious target is critical
+	if !opts.Ranged && (t.hasEffect("Paralyzed") || t.hasEffect("Unconscious")) {
+		critical = true
+	}
+
+	damage := opts.Damage
+	if critical {
+		damage = doubleDice(damage)
+		ct.logf("%s lands a critical hit on %s!", a.Name, t.Name)
+	} else {
+		ct.logf("%s hits %s!", a.Name, t.Name)
+	}
+	amount, rolls := damage.Roll()
+	if amount < 0 {
+		amount = 0
+	}
+	ct.printf("Rolled %s: %d %v\n", damage, amount, rolls)
+
+	ct.actor = &attacker
+	ct.AdjustHPTyped(target, -amount, opts.DamageType)
+	ct.actor = nil
+}
+
+// SetArmorClass sets combatants' armor class
+func (ct *CombatTracker) SetArmorClass(indices []int, ac int) {
+	for _, index := range indices {
+		if index < 0 || index >= len(ct.Combatants) {
+			ct.println("Invalid combatant index!")
+			return
+		}
+	}
+
+	for _, index := range indices {
+		c := &ct.Combatants[index]
+		c.AC = ac
+		ct.printf("%s has AC %d\n", c.Name, ac)
+	}
+
+	// Auto-save state
+	ct.AutoSave()
+}
+
+func cmdAttack(ct *CombatTracker, args []string) error {
+	usage := fmt.Errorf("usage: attack <attacker> <target> <bonus> <damage> [type] [adv|dis] [ranged]")
+	if len(args) < 4 {
+		return usage
+	}
+
+	attacker, err := ct.ResolveTarget(args[0])
+	if err != nil {
+		return err
+	}
+	target, err := ct.ResolveTarget(args[1])
+	if err != nil {
+		return err
+	}
+	if ct.Combatants[target].AC <= 0 {
+		return fmt.Errorf("%s has no AC, set it with ac <target> <AC> first", ct.Combatants[target].Name)
+	}
+
+	opts := AttackOptions{}
+	if opts.Bonus, err = strconv.Atoi(args[2]); err != nil {
+		return fmt.Errorf("invalid attack bonus %q, e.g. +5", args[2])
+	}
+	if opts.Damage, err = ParseDice(args[3]); err != nil {
+		return fmt.Errorf("invalid damage %q, e.g. 1d8+3", args[3])
+	}
+
+	damageType := []string{}
+	for _, arg := range args[4:] {
+		switch strings.ToLower(arg) {
+		case "adv", "advantage":
+			opts.Advantage = true
+		case "dis", "disadvantage":
+			opts.Disadvantage = true
+		case "ranged":
+			opts.Ranged = true
+		default:
+			damageType = append(damageType, strings.ToLower(arg))
+		}
+	}
+	opts.DamageType = strings.Join(damageType, " ")
+
+	ct.Attack(attacker, target, opts)
+	return nil
+}
+
+func cmdAC(ct *CombatTracker, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: ac <targets> <AC>")
+	}
+
+	indices, err := ct.resolveTargetList(args[0])
+	if err != nil {
+		return err
+	}
+	ac, err := strconv.Atoi(args[1])
+	if err != nil || ac < 1 {
+		return fmt.Errorf("AC must be a positive number")
+	}
+
+	ct.SetArmorClass(indices, ac)
+	return nil
+}
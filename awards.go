@@ -0,0 +1,207 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Award is the XP and loot from one encounter, kept in the party file
+type Award struct {
+	Encounter  string   `json:"encounter"`
+	Time       string   `json:"time"`
+	XP         int      `json:"xp"`         // Total XP from defeated monsters
+	Share      int      `json:"share"`      // XP each recipient got
+	Recipients []string `json:"recipients"` // Players the XP was split between
+	Loot       []string `json:"loot,omitempty"`
+}
+
+// encounterAward works out the XP and loot of the encounter that just ended.
+// XP from defeated monsters is split between every player, or only the ones
+// still standing when XPSurvivorsOnly is set. Monsters awarded when an earlier
+// combat ended are left out.
+func (ct *CombatTracker) encounterAward() Award {
+	award := Award{
+		Encounter:  ct.EncounterName,
+		Time:       clockNow().Format(time.RFC3339),
+		Recipients: []string{},
+	}
+
+	for _, c := range ct.Combatants {
+		if c.IsPlayer {
+			if c.CurrentHP > 0 || !ct.XPSurvivorsOnly {
+				award.Recipients = append(award.Recipients, c.Name)
+			}
+			continue
+		}
+		if c.Awarded {
+			continue
+		}
+
+		defeated := c.CurrentHP == 0 && c.MaxHP > 0
+		if defeated {
+			award.XP += c.XP
+		}
+		for _, item := range c.Loot {
+			if defeated {
+				award.Loot = append(award.Loot, fmt.Sprintf("%s: %s", c.Name, item))
+			} else {
+				award.Loot = append(award.Loot, fmt.Sprintf("%s (not defeated): %s", c.Name, item))
+			}
+		}
+	}
+
+	if len(award.Recipients) > 0 {
+		award.Share = award.XP / len(award.Recipients)
+	}
+	return award
+}
+
+// AwardEncounter shows the encounter's XP and loot and records them in the party
+// file. Defeated monsters are marked as awarded, so ending combat again with
+// them still in the tracker doesn't hand them out twice.
+func (ct *CombatTracker) AwardEncounter() {
+	award := ct.encounterAward()
+	for i := range ct.Combatants {
+		c := &ct.Combatants[i]
+		if !c.IsPlayer && c.CurrentHP == 0 && c.MaxHP > 0 {
+			c.Awarded = true
+		}
+	}
+	if award.XP == 0 && len(award.Loot) == 0 {
+		return
+	}
+
+	ct.println("\n===== SPOILS =====")
+	if award.XP > 0 {
+		switch len(award.Recipients) {
+		case 0:
+			ct.printf("%s XP from defeated monsters, but no one is left to share it.\n", formatXP(award.XP))
+		case 1:
+			ct.printf("%s XP from defeated monsters, all to %s.\n", formatXP(award.XP), award.Recipients[0])
+		default:
+			ct.printf("%s XP from defeated monsters, %s each for %s.\n",
+				formatXP(award.XP), formatXP(award.Share), strings.Join(award.Recipients, ", "))
+		}
+		ct.record(fmt.Sprintf("The party earns %s XP", formatXP(award.XP)))
+	}
+	if len(award.Loot) > 0 {
+		ct.println("Loot:")
+		for _, item := range award.Loot {
+			ct.printf("  %s\n", item)
+		}
+	}
+
+	party, err := LoadParty()
+	if err != nil {
+		ct.printf("Could not record the award: %v\n", err)
+		return
+	}
+	if len(party.Members) == 0 {
+		return
+	}
+	for _, name := range award.Recipients {
+		if m := party.member(name); m != nil {
+			m.XP += award.Share
+		}
+	}
+	party.Awards = append(party.Awards, award)
+	if err := party.Save(); err != nil {
+		ct.printf("Could not record the award: %v\n", err)
+	}
+}
+
+// AddLoot attaches a loot note to a combatant, handed out when combat ends
+func (ct *CombatTracker) AddLoot(index int, item string) {
+	if index < 0 || index >= len(ct.Combatants) {
+		ct.println("Invalid combatant index!")
+		return
+	}
+
+	c := &ct.Combatants[index]
+	c.Loot = append(c.Loot, item)
+	ct.printf("%s carries: %s\n", c.Name, item)
+
+	// Auto-save state
+	ct.AutoSave()
+}
+
+// ClearLoot removes every loot note from a combatant
+func (ct *CombatTracker) ClearLoot(index int) {
+	if index < 0 || index >= len(ct.Combatants) {
+		ct.println("Invalid combatant index!")
+		return
+	}
+
+	c := &ct.Combatants[index]
+	c.Loot = nil
+	ct.printf("%s carries nothing now.\n", c.Name)
+
+	// Auto-save state
+	ct.AutoSave()
+}
+
+// ShowLoot lists the loot every combatant carries
+func (ct *CombatTracker) ShowLoot() {
+	found := false
+	for _, c := range ct.Combatants {
+		for _, item := range c.Loot {
+			ct.printf("%-20s %s\n", c.Name, item)
+			found = true
+		}
+	}
+	if !found {
+		ct.println(`No loot yet, use loot <target> "<item>" to add some.`)
+	}
+}
+
+// SetXPSplit chooses whether XP goes to every player or only the ones still standing
+func (ct *CombatTracker) SetXPSplit(survivorsOnly bool) {
+	ct.XPSurvivorsOnly = survivorsOnly
+	if survivorsOnly {
+		ct.println("XP will be split between the players still standing when combat ends.")
+	} else {
+		ct.println("XP will be split between every player in the encounter.")
+	}
+
+	// Auto-save state
+	ct.AutoSave()
+}
+
+func cmdLoot(ct *CombatTracker, args []string) error {
+	if len(args) == 0 {
+		ct.ShowLoot()
+		return nil
+	}
+	if len(args) < 2 {
+		return fmt.Errorf(`usage: loot [<target> "<item>" | <target> clear]`)
+	}
+
+	index, err := ct.ResolveTarget(args[0])
+	if err != nil {
+		return err
+	}
+	item := strings.Join(args[1:], " ")
+	if strings.ToLower(item) == "clear" {
+		ct.ClearLoot(index)
+		return nil
+	}
+	ct.AddLoot(index, item)
+	return nil
+}
+
+func cmdXPSplit(ct *CombatTracker, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: xpsplit all|survivors")
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "all":
+		ct.SetXPSplit(false)
+	case "survivors":
+		ct.SetXPSplit(true)
+	default:
+		return fmt.Errorf("expected all or survivors, got %q", args[0])
+	}
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import "testing"
+
+func TestEndingCombatAgainAwardsOnce(t *testing.T) {
+	ct, _ := newTestTracker(t)
+	ct.AddCombatant("Thorin", 15, 30, true)
+	ct.AddCombatant("Goblin", 10, 7, false)
+	if err := ct.AddToParty([]int{0}); err != nil {
+		t.Fatal(err)
+	}
+	goblin := ct.indexByName("Goblin")
+	ct.Combatants[goblin].XP = 50
+
+	ct.StartCombat()
+	ct.AdjustHP(ct.indexByName("Goblin"), -7)
+	ct.EndCombat()
+	ct.StartCombat()
+	ct.EndCombat()
+
+	party, err := LoadParty()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if xp := party.member("Thorin").XP; xp != 50 {
+		t.Errorf("Thorin has %d XP, want 50", xp)
+	}
+	if len(party.Awards) != 1 {
+		t.Errorf("got %d awards, want 1: %+v", len(party.Awards), party.Awards)
+	}
+	if !ct.Combatants[ct.indexByName("Goblin")].Awarded {
+		t.Error("the goblin isn't marked as awarded")
+	}
+}
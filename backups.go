@@ -0,0 +1,253 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxActionBackups is how many backups of the most recent actions are kept. One
+// backup per round is kept on top of these.
+const maxActionBackups = 20
+
+// Backup file names inside the backup directory
+const (
+	actionBackupPrefix = "action-"
+	roundBackupPrefix  = "round-"
+)
+
+// Backup is a snapshot of a save file from the backup directory
+type Backup struct {
+	ID       string // "r3" for the start of round 3, a number for a recent action
+	Path     string
+	Round    int
+	Turn     string
+	SaveTime time.Time
+
+	written time.Time // When the backup file was written, to order backups saved within a second
+}
+
+// backupDir is the directory holding the backups of a save file
+func backupDir(filename string) string {
+	return filename + ".backups"
+}
+
+// writeBackup stores the save data last written to filename as the newest action
+// backup, and as the round backup when a new round is saved for the first time.
+// Old action backups beyond maxActionBackups are removed.
+func (ct *CombatTracker) writeBackup(filename string, data []byte) error {
+	dir := backupDir(filename)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	actions, err := filepath.Glob(filepath.Join(dir, actionBackupPrefix+"*.json"))
+	if err != nil {
+		return err
+	}
+	sort.Slice(actions, func(i, j int) bool { return backupNumber(actions[i]) < backupNumber(actions[j]) })
+
+	next := 1
+	if len(actions) > 0 {
+		next = backupNumber(actions[len(actions)-1]) + 1
+	}
+	name := filepath.Join(dir, fmt.Sprintf("%s%06d.json", actionBackupPrefix, next))
+	if err := writeFileAtomic(name, data, 0644); err != nil {
+		return err
+	}
+
+	for len(actions) >= maxActionBackups {
+		os.Remove(actions[0])
+		actions = actions[1:]
+	}
+
+	if ct.IsActive && ct.Round > ct.backupRound {
+		name := filepath.Join(dir, fmt.Sprintf("%s%03d.json", roundBackupPrefix, ct.Round))
+		if err := writeFileAtomic(name, data, 0644); err != nil {
+			return err
+		}
+		ct.backupRound = ct.Round
+	}
+	return nil
+}
+
+// flushBackups backs up the saves written since it was last called. A command
+// saves after each change it makes, but only leaves one backup of where it
+// finished, so a command hitting many targets doesn't use up the action backups.
+func (ct *CombatTracker) flushBackups() {
+	for filename, data := range ct.unbacked {
+		// A failed backup shouldn't fail the command
+		if err := ct.writeBackup(filename, data); err != nil {
+			ct.printf("Backup failed: %v\n", err)
+		}
+	}
+	ct.unbacked = nil
+}
+
+// backupNumber reads the number out of an action backup file name
+func backupNumber(path string) int {
+	name := strings.TrimSuffix(filepath.Base(path), ".json")
+	n, _ := strconv.Atoi(strings.TrimPrefix(name, actionBackupPrefix))
+	return n
+}
+
+// ListBackups returns the backups of a save file, oldest first
+func ListBackups(filename string) ([]Backup, error) {
+	paths, err := filepath.Glob(filepath.Join(backupDir(filename), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var backups []Backup
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".json")
+		var id string
+		switch {
+		case strings.HasPrefix(name, roundBackupPrefix):
+			n, _ := strconv.Atoi(strings.TrimPrefix(name, roundBackupPrefix))
+			id = fmt.Sprintf("r%d", n)
+		case strings.HasPrefix(name, actionBackupPrefix):
+			id = strconv.Itoa(backupNumber(path))
+		default:
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		backup := Backup{ID: id, Path: path, written: info.ModTime()}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var saveState SaveState
+		if json.Unmarshal(data, &saveState) != nil {
+			continue
+		}
+		saved := saveState.CombatTracker
+		backup.Round = saved.Round
+		if saved.IsActive && saved.CurrentTurnIdx >= 0 && saved.CurrentTurnIdx < len(saved.Combatants) {
+			backup.Turn = saved.turnName(saved.CurrentTurnIdx)
+		}
+		backup.SaveTime, _ = time.Parse(time.RFC3339, saveState.SaveTime)
+		backups = append(backups, backup)
+	}
+
+	sort.SliceStable(backups, func(i, j int) bool { return backups[i].written.Before(backups[j].written) })
+	return backups, nil
+}
+
+// findBackup looks a backup of filename up by its ID
+func findBackup(filename, id string) (Backup, error) {
+	backups, err := ListBackups(filename)
+	if err != nil {
+		return Backup{}, err
+	}
+	id = strings.ToLower(strings.TrimPrefix(id, "#"))
+	for _, backup := range backups {
+		if backup.ID == id {
+			return backup, nil
+		}
+	}
+	return Backup{}, fmt.Errorf("no backup %q, type backups for a list", id)
+}
+
+// ShowBackups lists the backups of the current save file
+func (ct *CombatTracker) ShowBackups() error {
+	if ct.SaveFilePath == "" {
+		return fmt.Errorf("no save file, so there are no backups")
+	}
+
+	backups, err := ListBackups(ct.SaveFilePath)
+	if err != nil {
+		return err
+	}
+	if len(backups) == 0 {
+		ct.printf("No backups of %s yet.\n", ct.SaveFilePath)
+		return nil
+	}
+
+	ct.printf("Backups of %s (rN is the start of round N):\n", ct.SaveFilePath)
+	ct.printf("  %-6s %-6s %-20s %s\n", "ID", "Round", "Turn", "Saved")
+	for _, backup := range backups {
+		turn := backup.Turn
+		if turn == "" {
+			turn = "-"
+		}
+		ct.printf("  %-6s %-6d %-20s %s\n", backup.ID, backup.Round, turn, backup.SaveTime.Format("2006-01-02 15:04:05"))
+	}
+	return nil
+}
+
+// PreviewBackup shows the combat state stored in a backup without restoring it
+func (ct *CombatTracker) PreviewBackup(id string) error {
+	if ct.SaveFilePath == "" {
+		return fmt.Errorf("no save file, so there are no backups")
+	}
+
+	backup, err := findBackup(ct.SaveFilePath, id)
+	if err != nil {
+		return err
+	}
+	preview, err := loadFromFile(backup.Path, io.Discard)
+	if err != nil {
+		return err
+	}
+
+	preview.SaveFilePath = ""
+	preview.SetOutput(ct.output())
+	ct.printf("Backup %s from %s:", backup.ID, backup.SaveTime.Format("2006-01-02 15:04:05"))
+	preview.DisplayCombatState()
+	return nil
+}
+
+// RestoreBackup replaces the combat state with a backup. The state before the
+// restore is already the newest backup, so a restore can be undone.
+func (ct *CombatTracker) RestoreBackup(id string) error {
+	if ct.SaveFilePath == "" {
+		return fmt.Errorf("no save file, so there are no backups")
+	}
+
+	backup, err := findBackup(ct.SaveFilePath, id)
+	if err != nil {
+		return err
+	}
+	restored, err := loadFromFile(backup.Path, io.Discard)
+	if err != nil {
+		return err
+	}
+
+	saveFilePath := ct.SaveFilePath
+	ct.replaceWith(restored)
+	ct.SaveFilePath = saveFilePath
+	ct.printf("Restored backup %s from %s.\n", backup.ID, backup.SaveTime.Format("2006-01-02 15:04:05"))
+
+	// Auto-save state
+	ct.AutoSave()
+	return nil
+}
+
+func cmdBackups(ct *CombatTracker, args []string) error {
+	return ct.ShowBackups()
+}
+
+func cmdPreview(ct *CombatTracker, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: preview <backup>")
+	}
+	return ct.PreviewBackup(args[0])
+}
+
+func cmdRestore(ct *CombatTracker, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: restore <backup>")
+	}
+	return ct.RestoreBackup(args[0])
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestOneBackupPerCommand(t *testing.T) {
+	ct, _ := newTestTracker(t)
+	ct.SaveFilePath = filepath.Join(t.TempDir(), "save.json")
+	session := NewSession(ct)
+
+	for _, line := range []string{
+		"add Goblin 12 7",
+		"dup goblin 3",
+		"dmg goblin,goblin2,goblin3,goblin4 2",
+	} {
+		if err := session.Execute(line); err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+	}
+
+	backups, err := ListBackups(ct.SaveFilePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 3 {
+		t.Fatalf("got %d backups after 3 commands, want 3", len(backups))
+	}
+	if id := backups[len(backups)-1].ID; id != "3" {
+		t.Errorf("newest backup is %q, want 3", id)
+	}
+}
@@ -0,0 +1,442 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// saveFileEnv names the environment variable used when -f isn't given
+const saveFileEnv = "COMBAT_TRACKER_FILE"
+
+// subcommand is a non-interactive command that works on a save file and exits
+type subcommand struct {
+	usage    string
+	summary  string
+	mutates  bool // Whether the save file is written back afterwards
+	creates  bool // Whether a missing save file starts a new encounter
+	optional bool // Whether it can run without a save file, on an empty encounter
+	run      func(ct *CombatTracker, args []string, opts *subcommandOptions) error
+}
+
+// subcommandOptions holds the flags shared by every subcommand
+type subcommandOptions struct {
+	saveFile  string
+	json      bool
+	format    string
+	output    string
+	gm        bool
+	diff      bool
+	keepGoing bool
+	fix       bool
+	stdout    io.Writer
+}
+
+// languageSubcommand runs a command of the command language with the subcommand's arguments
+func languageSubcommand(verb string) func(ct *CombatTracker, args []string, opts *subcommandOptions) error {
+	return func(ct *CombatTracker, args []string, opts *subcommandOptions) error {
+		return commands[verb](ct, args)
+	}
+}
+
+// subcommands lists everything that can follow the program name
+var subcommands = map[string]subcommand{
+	"add": {
+		usage: "add <name> <init> <hp> [pc]", summary: "add a combatant (creates the save file if needed)",
+		mutates: true, creates: true, run: languageSubcommand("add"),
+	},
+	"start": {
+		usage: "start", summary: "start combat",
+		mutates: true, run: languageSubcommand("start"),
+	},
+	"next": {
+		usage: "next", summary: "advance to the next turn",
+		mutates: true, run: languageSubcommand("next"),
+	},
+	"end": {
+		usage: "end", summary: "end combat",
+		mutates: true, run: languageSubcommand("end"),
+	},
+	"damage": {
+		usage: "damage <targets> <amount> [type]", summary: "damage one or more combatants",
+		mutates: true, run: languageSubcommand("dmg"),
+	},
+	"heal": {
+		usage: "heal <targets> <amount>", summary: "heal one or more combatants",
+		mutates: true, run: languageSubcommand("heal"),
+	},
+	"temp": {
+		usage: "temp <target> <amount>", summary: "give temporary HP",
+		mutates: true, run: languageSubcommand("temp"),
+	},
+	"cond": {
+		usage: "cond <target> +<effect> [<n>r] | -<effect>", summary: "add or remove status effects",
+		mutates: true, run: languageSubcommand("cond"),
+	},
+	"do": {
+		usage: "do <command...>", summary: "run any command of the command language",
+		mutates: true, creates: true, run: runDoSubcommand,
+	},
+	"run": {
+		usage: "run [-diff] [-k] <script...>", summary: "run command scripts (- reads stdin)",
+		mutates: true, creates: true, optional: true, run: runRunSubcommand,
+	},
+	"check": {
+		usage: "check [-fix]", summary: "report problems in the save file, -fix repairs what it can",
+		run: runCheckSubcommand,
+	},
+	"status": {
+		usage: "status", summary: "print a one-line summary of whose turn it is",
+		run: runStatusSubcommand,
+	},
+	"show": {
+		usage: "show [-json]", summary: "print the combat state, or the full state as JSON",
+		run: runShowSubcommand,
+	},
+	"stats": {
+		usage: "stats [-format text|md|csv] [-o file] [-gm]", summary: "print or export the statistics of the last combat",
+		run: runStatsSubcommand,
+	},
+	"export": {
+		usage: "export [-format md|csv|json] [-o file] [-gm]", summary: "export the initiative order",
+		run: runExportSubcommand,
+	},
+}
+
+// printSubcommandUsage lists the subcommands
+func printSubcommandUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: %s [-tui] [savefile.json]\n", os.Args[0])
+	fmt.Fprintf(w, "       %s <command> [-f savefile.json] [args...]\n\n", os.Args[0])
+	fmt.Fprintln(w, "Commands:")
+
+	names := make([]string, 0, len(subcommands))
+	for name := range subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-44s %s\n", subcommands[name].usage, subcommands[name].summary)
+	}
+	fmt.Fprintf(w, "\nThe save file defaults to $%s when -f isn't given.\n", saveFileEnv)
+}
+
+// runSubcommand runs a subcommand against a save file and returns the exit code
+func runSubcommand(name string, args []string) int {
+	sub := subcommands[name]
+
+	opts := &subcommandOptions{stdout: os.Stdout}
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.StringVar(&opts.saveFile, "f", os.Getenv(saveFileEnv), "save file to work on")
+	if name == "show" {
+		flags.BoolVar(&opts.json, "json", false, "print the full state as JSON")
+	}
+	if name == "export" {
+		flags.StringVar(&opts.format, "format", "md", "export format: md, csv or json")
+		flags.StringVar(&opts.output, "o", "", "write to this file instead of stdout")
+		flags.BoolVar(&opts.gm, "gm", false, "include hidden combatants and real names")
+	}
+	if name == "run" {
+		flags.BoolVar(&opts.diff, "diff", false, "print how the combat state changed")
+		flags.BoolVar(&opts.keepGoing, "k", false, "keep going after a failing line")
+	}
+	if name == "stats" {
+		flags.StringVar(&opts.format, "format", "text", "report format: text, md or csv")
+		flags.StringVar(&opts.output, "o", "", "write to this file instead of stdout")
+		flags.BoolVar(&opts.gm, "gm", false, "include hidden combatants and real names in md and csv")
+	}
+	if name == "check" {
+		flags.BoolVar(&opts.fix, "fix", false, "write the repaired save back")
+	}
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: %s %s\n", os.Args[0], sub.usage)
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	if opts.saveFile == "" && !sub.optional {
+		fmt.Fprintf(os.Stderr, "No save file given, use -f or set $%s\n", saveFileEnv)
+		return 2
+	}
+	partyBeside(opts.saveFile)
+
+	// Refuse to run while another tracker is using the save file
+	defer releaseSaveLocks()
+	if sub.mutates && opts.saveFile != "" {
+		if err := lockSaveFile(opts.saveFile); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+
+	var ct *CombatTracker
+	if opts.saveFile == "" {
+		ct = NewCombatTracker()
+	} else if _, err := os.Stat(opts.saveFile); os.IsNotExist(err) && sub.creates {
+		ct = NewCombatTracker()
+	} else {
+		ct, err = loadFromFile(opts.saveFile, io.Discard)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load %s: %v\n", opts.saveFile, err)
+			return 1
+		}
+	}
+
+	// Keep stdout clean for machine-readable output
+	if opts.json || opts.diff || (name == "export" && opts.output == "") || (name == "stats" && opts.format != "text" && opts.output == "") {
+		ct.SetOutput(os.Stderr)
+	}
+
+	code := 0
+	if err := sub.run(ct, flags.Args(), opts); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		// With -k the lines that worked are still saved
+		if !opts.keepGoing {
+			return 1
+		}
+		code = 1
+	}
+
+	if sub.mutates && opts.saveFile != "" {
+		if err := ct.writeSaveFile(opts.saveFile); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to save %s: %v\n", opts.saveFile, err)
+			return 1
+		}
+		ct.flushBackups()
+	}
+	return code
+}
+
+func runDoSubcommand(ct *CombatTracker, args []string, opts *subcommandOptions) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: do <command...>")
+	}
+
+	// Quote arguments again so names with spaces survive the round trip
+	words := make([]string, len(args))
+	for i, arg := range args {
+		if strings.ContainsAny(arg, " \t") {
+			arg = `"` + arg + `"`
+		}
+		words[i] = arg
+	}
+	return ct.ExecuteCommand(strings.Join(words, " "))
+}
+
+func runCheckSubcommand(ct *CombatTracker, args []string, opts *subcommandOptions) error {
+	// The tracker passed in was repaired as it loaded, so read the file again
+	saveState, err := readSaveFile(opts.saveFile, io.Discard)
+	if err != nil {
+		return err
+	}
+	problems := saveState.CombatTracker.ValidateAndRepair()
+	if len(problems) == 0 {
+		fmt.Fprintf(opts.stdout, "No problems found in %s.\n", opts.saveFile)
+		return nil
+	}
+
+	left := 0
+	for _, p := range problems {
+		fmt.Fprintln(opts.stdout, p)
+		if !p.Repaired {
+			left++
+		}
+	}
+	if !opts.fix {
+		if left == len(problems) {
+			return fmt.Errorf("found %d problem(s) that need fixing by hand", len(problems))
+		}
+		return fmt.Errorf("found %d problem(s), check -fix repairs what it can", len(problems))
+	}
+
+	if err := lockSaveFile(opts.saveFile); err != nil {
+		return err
+	}
+	if err := ct.writeSaveFile(opts.saveFile); err != nil {
+		return fmt.Errorf("error saving %s: %v", opts.saveFile, err)
+	}
+	if left > 0 {
+		return fmt.Errorf("repaired %d problem(s), %d need fixing by hand", len(problems)-left, left)
+	}
+	fmt.Fprintf(opts.stdout, "Repaired %d problem(s).\n", len(problems))
+	return nil
+}
+
+func runStatusSubcommand(ct *CombatTracker, args []string, opts *subcommandOptions) error {
+	if !ct.IsActive || ct.CurrentTurnIdx < 0 || ct.CurrentTurnIdx >= len(ct.Combatants) {
+		fmt.Fprintf(opts.stdout, "%s: combat not started (%d combatants)\n", ct.EncounterName, len(ct.Combatants))
+		return nil
+	}
+
+	c := ct.Combatants[ct.CurrentTurnIdx]
+	fmt.Fprintf(opts.stdout, "Round %d: %s's turn (HP %d/%d)\n", ct.Round, ct.turnName(ct.CurrentTurnIdx), c.CurrentHP, c.MaxHP)
+	return nil
+}
+
+func runShowSubcommand(ct *CombatTracker, args []string, opts *subcommandOptions) error {
+	if !opts.json {
+		ct.DisplayCombatState()
+		return nil
+	}
+
+	// Player tokens are secrets, keep them out of whatever the output is piped to
+	state := *ct
+	state.PlayerTokens = nil
+	data, err := json.MarshalIndent(&state, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error creating JSON: %v", err)
+	}
+	fmt.Fprintln(opts.stdout, string(data))
+	return nil
+}
+
+func runStatsSubcommand(ct *CombatTracker, args []string, opts *subcommandOptions) error {
+	if opts.format == "text" {
+		if ct.Stats == nil {
+			return fmt.Errorf("no combat statistics in %s yet", opts.saveFile)
+		}
+		ct.ShowStats()
+		return nil
+	}
+	return ct.exportStats(opts.format, opts.output, opts.stdout, opts.gm)
+}
+
+func runExportSubcommand(ct *CombatTracker, args []string, opts *subcommandOptions) error {
+	w := opts.stdout
+	if opts.output != "" {
+		file, err := os.Create(opts.output)
+		if err != nil {
+			return fmt.Errorf("error creating %s: %v", opts.output, err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	var err error
+	switch opts.format {
+	case "md", "markdown":
+		err = ct.ExportMarkdown(w, opts.gm)
+	case "csv":
+		err = ct.ExportCSV(w, opts.gm)
+	case "json":
+		var data []byte
+		data, err = json.MarshalIndent(ct.exportCombatants(opts.gm), "", "    ")
+		if err == nil {
+			_, err = fmt.Fprintln(w, string(data))
+		}
+	default:
+		return fmt.Errorf("unknown export format %q, use md, csv or json", opts.format)
+	}
+	if err != nil {
+		return err
+	}
+
+	if opts.output != "" {
+		ct.printf("Exported %s to %s\n", opts.format, opts.output)
+	}
+	return nil
+}
+
+// exportIndices lists the combatants an export includes. Exports are meant to be
+// shared, so hidden combatants are only included for the GM.
+func (ct *CombatTracker) exportIndices(gm bool) []int {
+	indices := make([]int, 0, len(ct.Combatants))
+	for i, c := range ct.Combatants {
+		if gm || !c.Hidden {
+			indices = append(indices, i)
+		}
+	}
+	return indices
+}
+
+// exportName is the name an export shows, the alias unless it's for the GM
+func exportName(c *Combatant, gm bool) string {
+	if gm {
+		return c.Name
+	}
+	return c.DisplayName()
+}
+
+// exportCombatants copies the exported combatants, with aliases in place of real
+// names and secret group names left out unless the export is for the GM
+func (ct *CombatTracker) exportCombatants(gm bool) []Combatant {
+	combatants := make([]Combatant, 0, len(ct.Combatants))
+	for _, i := range ct.exportIndices(gm) {
+		c := ct.Combatants[i]
+		if !gm {
+			c.Name = c.DisplayName()
+			c.Alias = ""
+			c.Group = ct.playerGroupName(i)
+		}
+		combatants = append(combatants, c)
+	}
+	return combatants
+}
+
+// ExportMarkdown writes the initiative order as a Markdown table
+func (ct *CombatTracker) ExportMarkdown(w io.Writer, gm bool) error {
+	fmt.Fprintf(w, "# %s: %s\n\n", ct.CampaignName, ct.EncounterName)
+	if ct.IsActive {
+		fmt.Fprintf(w, "Round %d\n\n", ct.Round)
+	}
+	fmt.Fprintln(w, "| # | Turn | Name | Type | Init | HP | Temp | Effects |")
+	fmt.Fprintln(w, "|---|------|------|------|------|----|------|---------|")
+	for n, i := range ct.exportIndices(gm) {
+		c := &ct.Combatants[i]
+		turn := ""
+		if ct.IsActive && (i == ct.CurrentTurnIdx || ct.inSameGroup(i, ct.CurrentTurnIdx)) {
+			turn = "→"
+		}
+		kind := "Monster"
+		if c.IsPlayer {
+			kind = "Player"
+		}
+		_, err := fmt.Fprintf(w, "| %d | %s | %s | %s | %d | %d/%d | %d | %s |\n",
+			n+1, turn, strings.ReplaceAll(exportName(c, gm), "|", `\|`), kind, c.Initiative,
+			c.CurrentHP, c.MaxHP, c.TemporaryHP, strings.Join(c.effectLabels(), ", "))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExportCSV writes the initiative order as CSV with a header row
+func (ct *CombatTracker) ExportCSV(w io.Writer, gm bool) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{"order", "current", "name", "type", "initiative", "currentHP", "maxHP", "temporaryHP", "conscious", "group", "effects"})
+	for n, i := range ct.exportIndices(gm) {
+		c := &ct.Combatants[i]
+		kind := "monster"
+		if c.IsPlayer {
+			kind = "player"
+		}
+		current := ct.IsActive && (i == ct.CurrentTurnIdx || ct.inSameGroup(i, ct.CurrentTurnIdx))
+		group := c.Group
+		if !gm {
+			group = ct.playerGroupName(i)
+		}
+		out.Write([]string{
+			strconv.Itoa(n + 1),
+			strconv.FormatBool(current),
+			exportName(c, gm),
+			kind,
+			strconv.Itoa(c.Initiative),
+			strconv.Itoa(c.CurrentHP),
+			strconv.Itoa(c.MaxHP),
+			strconv.Itoa(c.TemporaryHP),
+			strconv.FormatBool(c.IsConscious),
+			group,
+			strings.Join(c.StatusEffects, ";"),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"os"
+	"strings"
+)
+
+// ANSI SGR codes used by the palette
+const (
+	sgrBold    = "1"
+	sgrDim     = "2"
+	sgrReverse = "7"
+	sgrRed     = "31"
+	sgrGreen   = "32"
+	sgrYellow  = "33"
+	sgrMagenta = "35"
+	sgrCyan    = "36"
+	sgrBadge   = "30;43" // Black on yellow
+)
+
+// hpBarWidth is the number of cells in an HP bar
+const hpBarWidth = 10
+
+// Health states derived from the share of max HP left
+const (
+	HealthHealthy  = "healthy"
+	HealthBloodied = "bloodied" // At or below half HP
+	HealthCritical = "critical" // At or below a quarter HP
+	HealthDown     = "down"     // No HP left
+)
+
+// HealthState classifies a combatant's HP: bloodied at half, critical at a quarter
+func HealthState(currentHP, maxHP int) string {
+	switch {
+	case currentHP <= 0:
+		return HealthDown
+	case maxHP <= 0:
+		return HealthHealthy
+	case currentHP*4 <= maxHP:
+		return HealthCritical
+	case currentHP*2 <= maxHP:
+		return HealthBloodied
+	default:
+		return HealthHealthy
+	}
+}
+
+// palette colors terminal output, or leaves it plain when disabled
+type palette struct {
+	enabled bool
+}
+
+// colorAllowed reports whether the environment permits color on a terminal
+// writing to stdout: NO_COLOR must be unset and TERM must not be "dumb"
+func colorAllowed() bool {
+	if os.Getenv("NO_COLOR") != "" || os.Getenv("TERM") == "dumb" {
+		return false
+	}
+	return isTerminal(os.Stdout.Fd())
+}
+
+// colors returns the palette for engine output. Color is only used when messages
+// go straight to a terminal, never when they are redirected.
+func (ct *CombatTracker) colors() palette {
+	return palette{enabled: ct.out == nil && colorAllowed()}
+}
+
+// wrap surrounds s with the given SGR code
+func (p palette) wrap(code, s string) string {
+	if !p.enabled || s == "" {
+		return s
+	}
+	return "\x1b[" + code + "m" + s + "\x1b[0m"
+}
+
+// name colors a combatant name, players and monsters differently
+func (p palette) name(s string, isPlayer bool) string {
+	if isPlayer {
+		return p.wrap(sgrCyan, s)
+	}
+	return p.wrap(sgrMagenta, s)
+}
+
+// health colors s according to the health state
+func (p palette) health(s, state string) string {
+	switch state {
+	case HealthCritical:
+		return p.wrap(sgrRed+";"+sgrBold, s)
+	case HealthBloodied:
+		return p.wrap(sgrYellow, s)
+	case HealthDown:
+		return p.wrap(sgrDim, s)
+	default:
+		return p.wrap(sgrGreen, s)
+	}
+}
+
+// badge highlights a status effect
+func (p palette) badge(s string) string {
+	if !p.enabled {
+		return s
+	}
+	return p.wrap(sgrBadge, " "+s+" ")
+}
+
+// current highlights the combatant whose turn it is
+func (p palette) current(s string) string {
+	return p.wrap(sgrReverse+";"+sgrBold, s)
+}
+
+// hpBar draws a bar of hpBarWidth cells. With color it uses block characters in
+// the health color; without it falls back to "#" and "-".
+func (p palette) hpBar(currentHP, maxHP int) string {
+	filled := 0
+	if maxHP > 0 && currentHP > 0 {
+		filled = (currentHP*hpBarWidth + maxHP - 1) / maxHP
+		if filled > hpBarWidth {
+			filled = hpBarWidth
+		}
+	}
+
+	state := HealthState(currentHP, maxHP)
+	if !p.enabled {
+		return "[" + strings.Repeat("#", filled) + strings.Repeat("-", hpBarWidth-filled) + "]"
+	}
+	return p.health(strings.Repeat("█", filled), state) + p.wrap(sgrDim, strings.Repeat("░", hpBarWidth-filled))
+}
+
+// badges formats status effect labels, highlighted when color is on and in
+// brackets otherwise
+func (p palette) badges(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	if !p.enabled {
+		return " [" + strings.Join(labels, ", ") + "]"
+	}
+	parts := make([]string, len(labels))
+	for i, label := range labels {
+		parts[i] = p.badge(label)
+	}
+	return " " + strings.Join(parts, " ")
+}
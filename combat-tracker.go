@@ -1,10 +1,10 @@
 package main
 
 import (
-	"bufio"
 	"encoding/json"
+	"flag"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"os"
 	"sort"
 	"strconv"
@@ -22,20 +22,64 @@ type Combatant struct {
 	IsConscious   bool     `json:"isConscious"`
 	TemporaryHP   int      `json:"temporaryHP"`
 	StatusEffects []string `json:"statusEffects"`
+	Group         string   `json:"group,omitempty"` // Combatants sharing a group take a single turn together
+
+	EffectDurations map[string]int `json:"effectDurations,omitempty"` // Rounds left on timed status effects
+	Hidden          bool           `json:"hidden,omitempty"`          // Left out of everything players see until revealed
+	Alias           string         `json:"alias,omitempty"`           // Name shown to players instead of Name
+	CR              string         `json:"cr,omitempty"`              // Monster challenge rating, such as "1/4"
+	XP              int            `json:"xp,omitempty"`              // Experience the monster is worth
+	Level           int            `json:"level,omitempty"`           // Player character level
+	AC              int            `json:"ac,omitempty"`              // Armor class attacks are rolled against
+	Loot            []string       `json:"loot,omitempty"`            // Loot notes handed out when combat ends
+	TurnBudget      int            `json:"turnBudget,omitempty"`      // Seconds per turn, overriding the tracker's limit
+	TimeSpent       int            `json:"timeSpent,omitempty"`       // Seconds spent on turns so far
+	Awarded         bool           `json:"awarded,omitempty"`         // XP and loot already handed out when an earlier combat ended
 }
 
 // CombatTracker manages the combat encounter
 type CombatTracker struct {
-	Combatants     []Combatant `json:"combatants"`
-	Round          int         `json:"round"`
-	CurrentTurnIdx int         `json:"currentTurnIdx"`
-	IsActive       bool        `json:"isActive"`
-	CampaignName   string      `json:"campaignName"`
-	EncounterName  string      `json:"encounterName"`
-	SaveFilePath   string      `json:"-"`             // Track the save file path but don't include in JSON
-	StatusEffects  []string    `json:"statusEffects"` // List of available status effects
+	Combatants      []Combatant       `json:"combatants"`
+	Round           int               `json:"round"`
+	CurrentTurnIdx  int               `json:"currentTurnIdx"`
+	IsActive        bool              `json:"isActive"`
+	CampaignName    string            `json:"campaignName"`
+	EncounterName   string            `json:"encounterName"`
+	SaveFilePath    string            `json:"-"`                         // Track the save file path but don't include in JSON
+	StatusEffects   []string          `json:"statusEffects"`             // List of available status effects
+	CollapsedGroups map[string]bool   `json:"collapsedGroups,omitempty"` // Groups displayed as a single row
+	Log             []LogEntry        `json:"log,omitempty"`             // Recent combat events, oldest first
+	PlayerTokens    map[string]string `json:"playerTokens,omitempty"`    // Player API token to combatant name
+	RequireApproval bool              `json:"requireApproval,omitempty"` // Player updates wait for the GM
+	PendingUpdates  []PlayerUpdate    `json:"pendingUpdates,omitempty"`  // Player updates waiting for the GM
+	XPSurvivorsOnly bool              `json:"xpSurvivorsOnly,omitempty"` // XP only goes to players still standing
+	Stats           *CombatStats      `json:"stats,omitempty"`           // Statistics of the current or last combat
+	TurnLimit       int               `json:"turnLimit,omitempty"`       // Soft limit on each turn in seconds
+	TurnStarted     string            `json:"turnStarted,omitempty"`     // When the current turn began
+	RoundStarted    string            `json:"roundStarted,omitempty"`    // When the current round began
+
+	out         io.Writer            // Where engine messages go, stdout when nil
+	recorder    io.WriteCloser       // Where successful commands are recorded, if anywhere
+	onChange    func(*CombatTracker) // Called after each command, e.g. to publish to the web server
+	revision    int                  // Counts calls to changed, so screens can tell when to redraw
+	backupRound int                  // The last round a round backup was written for
+	actor       *int                 // Credited with damage and healing instead of whoever has the turn, when set
+	queuedRolls []int                // Dice results for the next command to use, from a recorded rolls line
+	scripts     []string             // Scripts being run, outermost first
+	unbacked    map[string][]byte    // Saves written by the running command, backed up when it ends
 }
 
+// LogEntry is one event in the combat log
+type LogEntry struct {
+	Round   int    `json:"round"`
+	Time    string `json:"time"`
+	Message string `json:"message"`
+	Secret  bool   `json:"secret,omitempty"` // Reveals a monster's numbers, kept from players
+}
+
+// maxLogEntries is how many combat log entries are kept in the save file
+const maxLogEntries = 200
+
 // SaveState represents the full state for saving/loading
 type SaveState struct {
 	CombatTracker CombatTracker `json:"combatTracker"`
@@ -43,10 +87,9 @@ type SaveState struct {
 	Version       string        `json:"version"`
 }
 
-// NewCombatTracker creates a new combat tracker
-func NewCombatTracker() *CombatTracker {
-	// Define default status effects
-	defaultEffects := []string{
+// defaultStatusEffects returns the standard list of status effects
+func defaultStatusEffects() []string {
+	return []string{
 		"Blinded",
 		"Charmed",
 		"Deafened",
@@ -63,7 +106,10 @@ func NewCombatTracker() *CombatTracker {
 		"Unconscious",
 		"Custom Status Effect",
 	}
+}
 
+// NewCombatTracker creates a new combat tracker
+func NewCombatTracker() *CombatTracker {
 	return &CombatTracker{
 		Combatants:     []Combatant{},
 		Round:          0,
@@ -72,7 +118,79 @@ func NewCombatTracker() *CombatTracker {
 		CampaignName:   "Default Campaign",
 		EncounterName:  "Unknown Encounter",
 		SaveFilePath:   "",
-		StatusEffects:  defaultEffects,
+		StatusEffects:  defaultStatusEffects(),
+	}
+}
+
+// SetOutput redirects engine messages, nil restores stdout
+func (ct *CombatTracker) SetOutput(w io.Writer) {
+	ct.out = w
+}
+
+// SetOnChange registers a function called with the tracker after each command
+func (ct *CombatTracker) SetOnChange(fn func(*CombatTracker)) {
+	ct.onChange = fn
+}
+
+// changed tells the registered listener that a command has run, and backs up
+// what the command saved
+func (ct *CombatTracker) changed() {
+	ct.flushBackups()
+	ct.revision++
+	if ct.onChange != nil {
+		ct.onChange(ct)
+	}
+}
+
+// output returns the writer engine messages go to
+func (ct *CombatTracker) output() io.Writer {
+	if ct.out == nil {
+		return os.Stdout
+	}
+	return ct.out
+}
+
+func (ct *CombatTracker) printf(format string, a ...interface{}) {
+	fmt.Fprintf(ct.output(), format, a...)
+}
+
+func (ct *CombatTracker) println(a ...interface{}) {
+	fmt.Fprintln(ct.output(), a...)
+}
+
+func (ct *CombatTracker) print(a ...interface{}) {
+	fmt.Fprint(ct.output(), a...)
+}
+
+// logf prints a message as a line and records it in the combat log
+func (ct *CombatTracker) logf(format string, a ...interface{}) {
+	message := fmt.Sprintf(format, a...)
+	ct.println(message)
+	ct.record(message)
+}
+
+// logStatsf is logf for messages that give away a combatant's numbers, which are
+// kept out of the player view for monsters
+func (ct *CombatTracker) logStatsf(isPlayer bool, format string, a ...interface{}) {
+	message := fmt.Sprintf(format, a...)
+	ct.println(message)
+	ct.addLogEntry(message, !isPlayer)
+}
+
+// record adds a message to the combat log without printing it
+func (ct *CombatTracker) record(message string) {
+	ct.addLogEntry(message, false)
+}
+
+func (ct *CombatTracker) addLogEntry(message string, secret bool) {
+	ct.Log = append(ct.Log, LogEntry{
+		Round:   ct.Round,
+		Time:    clockNow().Format(time.RFC3339),
+		Message: message,
+		Secret:  secret,
+	})
+	if len(ct.Log) > maxLogEntries {
+		ct.Log = ct.Log[len(ct.Log)-maxLogEntries:]
 	}
 }
 
@@ -89,22 +207,117 @@ func (ct *CombatTracker) AddCombatant(name string, initiative, maxHP int, isPlay
 		StatusEffects: []string{},
 	}
 	ct.Combatants = append(ct.Combatants, combatant)
+	ct.record(fmt.Sprintf("%s joins the encounter", name))
 
 	// Auto-save state
 	ct.AutoSave()
 }
 
-// SortByInitiative sorts combatants by initiative (highest first)
+// SortByInitiative sorts combatants by initiative (highest first), keeping group members together
 func (ct *CombatTracker) SortByInitiative() {
-	sort.Slice(ct.Combatants, func(i, j int) bool {
-		return ct.Combatants[i].Initiative > ct.Combatants[j].Initiative
+	ct.sortByInitiative()
+}
+
+// sortByInitiative sorts like SortByInitiative and returns the old index of the
+// combatant now at each position
+func (ct *CombatTracker) sortByInitiative() []int {
+	// Ties are broken by the first position of each combatant's group so that
+	// members of a group always end up next to each other
+	firstPos := make(map[string]int)
+	for i, c := range ct.Combatants {
+		if c.Group == "" {
+			continue
+		}
+		if _, ok := firstPos[c.Group]; !ok {
+			firstPos[c.Group] = i
+		}
+	}
+
+	anchors := make(map[*Combatant]int, len(ct.Combatants))
+	for i := range ct.Combatants {
+		c := &ct.Combatants[i]
+		if c.Group != "" {
+			anchors[c] = firstPos[c.Group]
+		} else {
+			anchors[c] = i
+		}
+	}
+
+	order := make([]int, len(ct.Combatants))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		ca, cb := &ct.Combatants[order[a]], &ct.Combatants[order[b]]
+		if ca.Initiative != cb.Initiative {
+			return ca.Initiative > cb.Initiative
+		}
+		return anchors[ca] < anchors[cb]
 	})
+
+	sorted := make([]Combatant, len(ct.Combatants))
+	for i, idx := range order {
+		sorted[i] = ct.Combatants[idx]
+	}
+	ct.Combatants = sorted
+	return order
+}
+
+// resortKeepingTurn sorts by initiative in the middle of combat. The turn stays
+// with the combatant who had it, found by position rather than name since names
+// can repeat.
+func (ct *CombatTracker) resortKeepingTurn() {
+	current := ct.CurrentTurnIdx
+	for i, old := range ct.sortByInitiative() {
+		if old == current {
+			ct.CurrentTurnIdx = ct.slotStart(i)
+			return
+		}
+	}
+}
+
+// inSameGroup reports whether the combatants at i and j share a group turn
+func (ct *CombatTracker) inSameGroup(i, j int) bool {
+	group := ct.Combatants[i].Group
+	return group != "" && group == ct.Combatants[j].Group
+}
+
+// groupMembers returns the indices of every combatant in the same group as the one at index
+func (ct *CombatTracker) groupMembers(index int) []int {
+	if ct.Combatants[index].Group == "" {
+		return []int{index}
+	}
+
+	members := []int{}
+	for i := range ct.Combatants {
+		if ct.inSameGroup(i, index) || i == index {
+			members = append(members, i)
+		}
+	}
+	return members
+}
+
+// turnName returns the name to announce for the turn starting at index
+func (ct *CombatTracker) turnName(index int) string {
+	members := ct.groupMembers(index)
+	if len(members) == 1 {
+		return ct.Combatants[index].Name
+	}
+
+	names := make([]string, len(members))
+	for i, m := range members {
+		names[i] = ct.Combatants[m].Name
+	}
+	return fmt.Sprintf("%s group (%s)", ct.Combatants[index].Group, strings.Join(names, ", "))
 }
 
 // StartCombat begins the combat encounter
 func (ct *CombatTracker) StartCombat() {
+	// Anyone in the party who isn't here yet joins in
+	ct.PullParty(false)
+
 	if len(ct.Combatants) == 0 {
-		fmt.Println("Cannot start combat with no combatants!")
+		ct.println("Cannot start combat with no combatants!")
 		return
 	}
 
@@ -112,10 +325,14 @@ func (ct *CombatTracker) StartCombat() {
 	ct.Round = 1
 	ct.CurrentTurnIdx = 0
 	ct.IsActive = true
+	ct.backupRound = 0 // A new combat gets new round backups
+	ct.startStats()
+	ct.startTurnTimer()
 
-	fmt.Println("\n===== COMBAT BEGINS =====")
-	fmt.Printf("Round %d\n", ct.Round)
-	fmt.Printf("It's %s's turn!\n", ct.Combatants[ct.CurrentTurnIdx].Name)
+	ct.println("\n===== COMBAT BEGINS =====")
+	ct.printf("Round %d\n", ct.Round)
+	ct.record("Combat begins")
+	ct.logf("It's %s's turn!", ct.turnName(ct.CurrentTurnIdx))
 
 	// Auto-save state
 	ct.AutoSave()
@@ -124,18 +341,35 @@ func (ct *CombatTracker) StartCombat() {
 // NextTurn advances to the next combatant's turn
 func (ct *CombatTracker) NextTurn() {
 	if !ct.IsActive {
-		fmt.Println("Combat hasn't started yet!")
+		ct.println("Combat hasn't started yet!")
 		return
 	}
 
-	ct.CurrentTurnIdx++
+	ct.endTurnTimer()
+
+	// Skip over the rest of the current group, it shares a single turn
+	next := ct.CurrentTurnIdx + 1
+	for next < len(ct.Combatants) && ct.CurrentTurnIdx >= 0 && ct.inSameGroup(next, ct.CurrentTurnIdx) {
+		next++
+	}
+
+	ct.CurrentTurnIdx = next
 	if ct.CurrentTurnIdx >= len(ct.Combatants) {
+		ct.endRoundTimer()
 		ct.Round++
 		ct.CurrentTurnIdx = 0
-		fmt.Printf("\n===== ROUND %d =====\n", ct.Round)
+		ct.printf("\n===== ROUND %d =====\n", ct.Round)
+		ct.record(fmt.Sprintf("Round %d begins", ct.Round))
 	}
 
-	fmt.Printf("It's %s's turn!\n", ct.Combatants[ct.CurrentTurnIdx].Name)
+	ct.logf("It's %s's turn!", ct.turnName(ct.CurrentTurnIdx))
+	ct.noteTurn()
+	ct.startTurnTimer()
+
+	// Timed effects run down at the start of their owner's turn
+	for _, m := range ct.groupMembers(ct.CurrentTurnIdx) {
+		ct.tickEffectDurations(m)
+	}
 
 	// Auto-save state
 	ct.AutoSave()
@@ -143,8 +377,14 @@ func (ct *CombatTracker) NextTurn() {
 
 // AdjustHP changes a combatant's hit points
 func (ct *CombatTracker) AdjustHP(index int, amount int) {
+	ct.AdjustHPTyped(index, amount, "")
+}
+
+// AdjustHPTyped is AdjustHP for damage of a type such as "fire", which goes in
+// the combat log along with the damage taken
+func (ct *CombatTracker) AdjustHPTyped(index int, amount int, damageType string) {
 	if index < 0 || index >= len(ct.Combatants) {
-		fmt.Println("Invalid combatant index!")
+		ct.println("Invalid combatant index!")
 		return
 	}
 
@@ -153,6 +393,10 @@ func (ct *CombatTracker) AdjustHP(index int, amount int) {
 	// Handle damage
 	if amount < 0 {
 		damage := -amount
+		wasUp := c.CurrentHP > 0
+		if damageType != "" {
+			ct.logf("%s takes %d %s damage", c.Name, damage, damageType)
+		}
 
 		// Apply temporary HP first
 		if c.TemporaryHP > 0 {
@@ -174,25 +418,28 @@ func (ct *CombatTracker) AdjustHP(index int, amount int) {
 		if c.CurrentHP <= 0 {
 			c.CurrentHP = 0
 			c.IsConscious = false
-			fmt.Printf("%s falls unconscious!\n", c.Name)
+			ct.logf("%s falls unconscious!", c.Name)
 		}
+		ct.noteDamage(index, -amount, wasUp && c.CurrentHP == 0)
 	} else {
 		// Handle healing
+		before := c.CurrentHP
 		c.CurrentHP += amount
 		if c.CurrentHP > c.MaxHP {
 			c.CurrentHP = c.MaxHP
 		}
+		ct.noteHealing(c.CurrentHP - before)
 		if !c.IsConscious && c.CurrentHP > 0 {
 			c.IsConscious = true
-			fmt.Printf("%s regains consciousness!\n", c.Name)
+			ct.logf("%s regains consciousness!", c.Name)
 		}
 	}
 
-	fmt.Printf("%s HP: %d/%d", c.Name, c.CurrentHP, c.MaxHP)
+	tempHPStr := ""
 	if c.TemporaryHP > 0 {
-		fmt.Printf(" (Temp: %d)", c.TemporaryHP)
+		tempHPStr = fmt.Sprintf(" (Temp: %d)", c.TemporaryHP)
 	}
-	fmt.Println()
+	ct.logStatsf(c.IsPlayer, "%s HP: %d/%d%s", c.Name, c.CurrentHP, c.MaxHP, tempHPStr)
 
 	// Auto-save state
 	ct.AutoSave()
@@ -201,7 +448,7 @@ func (ct *CombatTracker) AdjustHP(index int, amount int) {
 // AddTemporaryHP adds temporary hit points to a combatant
 func (ct *CombatTracker) AddTemporaryHP(index int, amount int) {
 	if index < 0 || index >= len(ct.Combatants) {
-		fmt.Println("Invalid combatant index!")
+		ct.println("Invalid combatant index!")
 		return
 	}
 
@@ -210,9 +457,9 @@ func (ct *CombatTracker) AddTemporaryHP(index int, amount int) {
 	// Temporary HP doesn't stack, take the higher value
 	if amount > c.TemporaryHP {
 		c.TemporaryHP = amount
-		fmt.Printf("%s now has %d temporary hit points!\n", c.Name, c.TemporaryHP)
+		ct.logStatsf(c.IsPlayer, "%s now has %d temporary hit points!", c.Name, c.TemporaryHP)
 	} else {
-		fmt.Printf("%s already has %d temporary hit points, which is higher!\n", c.Name, c.TemporaryHP)
+		ct.printf("%s already has %d temporary hit points, which is higher!\n", c.Name, c.TemporaryHP)
 	}
 
 	// Auto-save state
@@ -222,22 +469,60 @@ func (ct *CombatTracker) AddTemporaryHP(index int, amount int) {
 // AddStatusEffect adds a status effect to a combatant
 func (ct *CombatTracker) AddStatusEffect(index int, effect string) {
 	if index < 0 || index >= len(ct.Combatants) {
-		fmt.Println("Invalid combatant index!")
+		ct.println("Invalid combatant index!")
 		return
 	}
 
 	c := &ct.Combatants[index]
 	c.StatusEffects = append(c.StatusEffects, effect)
-	fmt.Printf("%s is now affected by: %s\n", c.Name, effect)
+	ct.logf("%s is now affected by: %s", c.Name, effect)
+	ct.noteCondition(index, effect)
 
 	// Auto-save state
 	ct.AutoSave()
 }
 
+// AddTimedStatusEffect adds a status effect that wears off after the given number of rounds
+func (ct *CombatTracker) AddTimedStatusEffect(index int, effect string, rounds int) {
+	if index < 0 || index >= len(ct.Combatants) {
+		ct.println("Invalid combatant index!")
+		return
+	}
+
+	c := &ct.Combatants[index]
+	if c.EffectDurations == nil {
+		c.EffectDurations = make(map[string]int)
+	}
+	c.EffectDurations[effect] = rounds
+	ct.printf("%s will last %d round(s)\n", effect, rounds)
+
+	ct.AddStatusEffect(index, effect)
+}
+
+// tickEffectDurations counts down timed effects on a combatant and removes expired ones
+func (ct *CombatTracker) tickEffectDurations(index int) {
+	c := &ct.Combatants[index]
+
+	expired := []string{}
+	for effect, rounds := range c.EffectDurations {
+		if rounds <= 1 {
+			expired = append(expired, effect)
+		} else {
+			c.EffectDurations[effect] = rounds - 1
+		}
+	}
+
+	sort.Strings(expired)
+	for _, effect := range expired {
+		ct.logf("%s on %s has worn off.", effect, c.Name)
+		ct.RemoveStatusEffect(index, effect)
+	}
+}
+
 // RemoveStatusEffect removes a status effect from a combatant
 func (ct *CombatTracker) RemoveStatusEffect(index int, effect string) {
 	if index < 0 || index >= len(ct.Combatants) {
-		fmt.Println("Invalid combatant index!")
+		ct.println("Invalid combatant index!")
 		return
 	}
 
@@ -248,7 +533,8 @@ func (ct *CombatTracker) RemoveStatusEffect(index int, effect string) {
 			// Remove the effect by replacing it with the last element and then truncating
 			c.StatusEffects[i] = c.StatusEffects[len(c.StatusEffects)-1]
 			c.StatusEffects = c.StatusEffects[:len(c.StatusEffects)-1]
-			fmt.Printf("%s is no longer affected by: %s\n", c.Name, effect)
+			delete(c.EffectDurations, effect)
+			ct.logf("%s is no longer affected by: %s", c.Name, effect)
 
 			// Auto-save state
 			ct.AutoSave()
@@ -256,41 +542,67 @@ func (ct *CombatTracker) RemoveStatusEffect(index int, effect string) {
 		}
 	}
 
-	fmt.Printf("%s was not affected by: %s\n", c.Name, effect)
+	ct.printf("%s was not affected by: %s\n", c.Name, effect)
 }
 
 // DisplayCombatState shows the current state of all combatants
 func (ct *CombatTracker) DisplayCombatState() {
-	fmt.Println("\n===== COMBAT STATE =====")
+	ct.println("\n===== COMBAT STATE =====")
 	if ct.CampaignName != "" || ct.EncounterName != "" {
-		fmt.Printf("Campaign: %s | Encounter: %s\n", ct.CampaignName, ct.EncounterName)
+		ct.printf("Campaign: %s | Encounter: %s\n", ct.CampaignName, ct.EncounterName)
+	}
+	if d, ok := ct.Difficulty(); ok {
+		ct.println(d.summary())
 	}
 	if ct.SaveFilePath != "" {
-		fmt.Printf("Auto-saving to: %s\n", ct.SaveFilePath)
+		ct.printf("Auto-saving to: %s\n", ct.SaveFilePath)
 	}
-	fmt.Printf("Round: %d\n", ct.Round)
-	fmt.Println("-------------------")
+	ct.printf("Round: %d\n", ct.Round)
+	if timer := ct.turnTimer(); timer != "" {
+		ct.println(timer)
+	}
+	ct.println("-------------------")
 
+	colors := ct.colors()
 	for i, c := range ct.Combatants {
+		members := ct.groupMembers(i)
+		if len(members) > 1 && members[0] == i {
+			ct.displayGroupRow(i)
+		}
+		if len(members) > 1 && ct.CollapsedGroups[c.Group] {
+			continue
+		}
+
 		currentTurnMarker := " "
-		if i == ct.CurrentTurnIdx && ct.IsActive {
+		if i == ct.CurrentTurnIdx && ct.IsActive && len(members) == 1 {
 			currentTurnMarker = "→"
 		}
 
-		statusStr := ""
-		if len(c.StatusEffects) > 0 {
-			statusStr = fmt.Sprintf(" [%s]", strings.Join(c.StatusEffects, ", "))
-		}
+		statusStr := colors.badges(c.effectLabels())
 
 		consciousnessStr := ""
 		if !c.IsConscious {
 			consciousnessStr = " (Unconscious)"
+		} else if state := HealthState(c.CurrentHP, c.MaxHP); state != HealthHealthy {
+			consciousnessStr = colors.health(" ("+strings.ToUpper(state[:1])+state[1:]+")", state)
 		}
 
 		tempHPStr := ""
 		if c.TemporaryHP > 0 {
 			tempHPStr = fmt.Sprintf(" (Temp: %d)", c.TemporaryHP)
 		}
+		if c.AC > 0 {
+			tempHPStr += fmt.Sprintf(" AC: %d", c.AC)
+		}
+
+		// Only the GM sees these
+		secretStr := ""
+		if c.Alias != "" {
+			secretStr += colors.wrap(sgrDim, fmt.Sprintf(" as %q", c.Alias))
+		}
+		if c.Hidden {
+			secretStr += colors.wrap(sgrDim, " (Hidden)")
+		}
 
 		playerMarker := " "
 		if c.IsPlayer {
@@ -299,25 +611,125 @@ func (ct *CombatTracker) DisplayCombatState() {
 			playerMarker = "M"
 		}
 
-		fmt.Printf("%s %s %2d. %-20s Init: %2d HP: %3d/%-3d%s%s%s\n",
-			currentTurnMarker, playerMarker, i+1, c.Name, c.Initiative,
-			c.CurrentHP, c.MaxHP, tempHPStr, consciousnessStr, statusStr)
+		// Pad before coloring so the escape codes don't upset the columns
+		nameStr := colors.name(fmt.Sprintf("%-20s", c.Name), c.IsPlayer)
+		if currentTurnMarker != " " {
+			nameStr = colors.current(fmt.Sprintf("%-20s", c.Name))
+		}
+
+		ct.printf("%s %s %2d. %s Init: %2d HP: %s %s%s%s%s%s\n",
+			currentTurnMarker, playerMarker, i+1, nameStr, c.Initiative,
+			colors.health(fmt.Sprintf("%3d/%-3d", c.CurrentHP, c.MaxHP), HealthState(c.CurrentHP, c.MaxHP)),
+			colors.hpBar(c.CurrentHP, c.MaxHP), tempHPStr, consciousnessStr, secretStr, statusStr)
+	}
+	ct.println("-------------------")
+}
+
+// effectLabels returns the combatant's status effects with any remaining duration
+func (c *Combatant) effectLabels() []string {
+	labels := make([]string, len(c.StatusEffects))
+	for i, effect := range c.StatusEffects {
+		if rounds, ok := c.EffectDurations[effect]; ok {
+			labels[i] = fmt.Sprintf("%s %dr", effect, rounds)
+		} else {
+			labels[i] = effect
+		}
+	}
+	return labels
+}
+
+// displayGroupRow prints the summary row for the group starting at index
+func (ct *CombatTracker) displayGroupRow(index int) {
+	members := ct.groupMembers(index)
+	group := ct.Combatants[index].Group
+
+	currentTurnMarker := " "
+	if ct.IsActive && ct.CurrentTurnIdx >= 0 && ct.CurrentTurnIdx < len(ct.Combatants) && ct.inSameGroup(index, ct.CurrentTurnIdx) {
+		currentTurnMarker = "→"
+	}
+
+	currentHP, maxHP, down := 0, 0, 0
+	for _, m := range members {
+		currentHP += ct.Combatants[m].CurrentHP
+		maxHP += ct.Combatants[m].MaxHP
+		if !ct.Combatants[m].IsConscious {
+			down++
+		}
+	}
+
+	downStr := ""
+	if down > 0 {
+		downStr = fmt.Sprintf(" (%d down)", down)
+	}
+
+	collapsedStr := ""
+	if ct.CollapsedGroups[group] {
+		collapsedStr = fmt.Sprintf(" [collapsed: #%d-%d]", members[0]+1, members[len(members)-1]+1)
 	}
-	fmt.Println("-------------------")
+
+	colors := ct.colors()
+	nameStr := colors.name(fmt.Sprintf("%-20s", fmt.Sprintf("%s x%d", group, len(members))), false)
+	if currentTurnMarker != " " {
+		nameStr = colors.current(fmt.Sprintf("%-20s", fmt.Sprintf("%s x%d", group, len(members))))
+	}
+
+	ct.printf("%s G     %s Init: %2d HP: %s %s%s%s\n",
+		currentTurnMarker, nameStr, ct.Combatants[index].Initiative,
+		colors.health(fmt.Sprintf("%3d/%-3d", currentHP, maxHP), HealthState(currentHP, maxHP)),
+		colors.hpBar(currentHP, maxHP), downStr, collapsedStr)
+}
+
+// ToggleGroupCollapsed switches a group between a single summary row and one row per member
+func (ct *CombatTracker) ToggleGroupCollapsed(index int) {
+	if index < 0 || index >= len(ct.Combatants) {
+		ct.println("Invalid combatant index!")
+		return
+	}
+
+	group := ct.Combatants[index].Group
+	if group == "" {
+		ct.printf("%s is not part of a group!\n", ct.Combatants[index].Name)
+		return
+	}
+
+	if ct.CollapsedGroups == nil {
+		ct.CollapsedGroups = make(map[string]bool)
+	}
+	if ct.CollapsedGroups[group] {
+		delete(ct.CollapsedGroups, group)
+		ct.printf("Expanded group %s\n", group)
+	} else {
+		ct.CollapsedGroups[group] = true
+		ct.printf("Collapsed group %s\n", group)
+	}
+
+	// Auto-save state
+	ct.AutoSave()
 }
 
 // EndCombat ends the current combat
 func (ct *CombatTracker) EndCombat() {
 	if !ct.IsActive {
-		fmt.Println("No active combat to end!")
+		ct.println("No active combat to end!")
 		return
 	}
 
+	ct.endTurnTimer()
+	ct.RoundStarted = ""
+	if ct.Stats != nil {
+		ct.Stats.Ended = clockNow().Format(time.RFC3339)
+	}
 	ct.IsActive = false
-	fmt.Println("\n===== COMBAT ENDED =====")
+	ct.println("\n===== COMBAT ENDED =====")
+	ct.record("Combat ended")
+
+	// Hand out XP and loot, and carry the party's HP and conditions over to the next encounter
+	ct.AwardEncounter()
+	ct.saveParty()
 
 	// Display final combat state
 	ct.DisplayCombatState()
+	ct.ShowStats()
 
 	// Auto-save state
 	ct.AutoSave()
@@ -325,11 +737,21 @@ func (ct *CombatTracker) EndCombat() {
 
 // SaveToFile saves the current combat state to a file
 func (ct *CombatTracker) SaveToFile(filename string) error {
+	if err := ct.writeSaveFile(filename); err != nil {
+		return err
+	}
+
+	ct.printf("Combat state saved to %s\n", filename)
+	return nil
+}
+
+// writeSaveFile writes the save state to filename without printing anything
+func (ct *CombatTracker) writeSaveFile(filename string) error {
 	// Create a save state object
 	saveState := SaveState{
 		CombatTracker: *ct,
-		SaveTime:      time.Now().Format(time.RFC3339),
-		Version:       "1.0.0",
+		SaveTime:      clockNow().Format(time.RFC3339),
+		Version:       CurrentSaveVersion,
 	}
 
 	// Convert to JSON
@@ -338,13 +760,57 @@ func (ct *CombatTracker) SaveToFile(filename string) error {
 		return fmt.Errorf("error creating JSON: %v", err)
 	}
 
-	// Write to file
-	err = ioutil.WriteFile(filename, jsonData, 0644)
+	// Only one tracker may write a save file
+	if err := lockSaveFile(filename); err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename it, so a failed write can't truncate the save
+	err = writeFileAtomic(filename, jsonData, 0644)
 	if err != nil {
 		return fmt.Errorf("error writing to file: %v", err)
 	}
 
-	fmt.Printf("Combat state saved to %s\n", filename)
+	// Backed up once the command is done, however many times it saves
+	if ct.unbacked == nil {
+		ct.unbacked = make(map[string][]byte)
+	}
+	ct.unbacked[filename] = jsonData
+
+	return nil
+}
+
+// defaultSaveFilename returns the current save path, or a timestamped name based on the encounter
+func (ct *CombatTracker) defaultSaveFilename() string {
+	if ct.SaveFilePath != "" {
+		return ct.SaveFilePath
+	}
+
+	// Generate a default filename with timestamp
+	return fmt.Sprintf("combat_%s_%s_%s.json",
+		strings.ReplaceAll(ct.CampaignName, " ", "_"),
+		strings.ReplaceAll(ct.EncounterName, " ", "_"),
+		clockNow().Format("2006-01-02_15-04-05"))
+}
+
+// SaveAs saves to filename (or the default save filename when empty) and auto-saves there from now on
+func (ct *CombatTracker) SaveAs(filename string) error {
+	if filename == "" {
+		filename = ct.defaultSaveFilename()
+	}
+
+	// Add .json extension if not present
+	if !strings.HasSuffix(filename, ".json") {
+		filename += ".json"
+	}
+
+	err := ct.SaveToFile(filename)
+	if err != nil {
+		return err
+	}
+
+	// Update the save file path for auto-saves
+	ct.SaveFilePath = filename
 	return nil
 }
 
@@ -354,63 +820,75 @@ func (ct *CombatTracker) AutoSave() {
 		return // No auto-save file configured
 	}
 
-	err := ct.SaveToFile(ct.SaveFilePath)
+	// Saved quietly, the save path is already shown with the combat state
+	err := ct.writeSaveFile(ct.SaveFilePath)
 	if err != nil {
-		fmt.Printf("Auto-save failed: %v\n", err)
+		ct.printf("Auto-save failed: %v\n", err)
 	}
 }
 
 // LoadFromFile loads a combat state from a file
 func LoadFromFile(filename string) (*CombatTracker, error) {
+	return loadFromFile(filename, os.Stdout)
+}
+
+// loadFromFile loads a combat state from a file, writing any messages to out
+func loadFromFile(filename string, out io.Writer) (*CombatTracker, error) {
+	saveState, err := readSaveFile(filename, out)
+	if err != nil {
+		return nil, err
+	}
+
+	// Set the save file path for auto-save
+	saveState.CombatTracker.SaveFilePath = filename
+
+	// If no status effects are present, initialize with defaults
+	if len(saveState.CombatTracker.StatusEffects) == 0 {
+		saveState.CombatTracker.StatusEffects = defaultStatusEffects()
+		fmt.Fprintln(out, "Initialized default status effects list.")
+	}
+
+	// Repair anything that would trip the tracker up later
+	printSaveProblems(out, filename, saveState.CombatTracker.ValidateAndRepair())
+
+	// The current round already has its round backup, if it's going to have one
+	saveState.CombatTracker.backupRound = saveState.CombatTracker.Round
+
+	fmt.Fprintf(out, "Loaded save from: %s\n", saveState.SaveTime)
+	return &saveState.CombatTracker, nil
+}
+
+// readSaveFile reads and parses a save file, upgrading it from older versions
+func readSaveFile(filename string, out io.Writer) (*SaveState, error) {
 	// Read file
-	data, err := ioutil.ReadFile(filename)
+	data, err := os.ReadFile(filename)
 	if err != nil {
 		return nil, fmt.Errorf("error reading file: %v", err)
 	}
 
+	// Bring saves from older versions up to date
+	data, version, err := migrateSave(data)
+	if err != nil {
+		return nil, err
+	}
+	if version != CurrentSaveVersion {
+		fmt.Fprintf(out, "Upgraded save from version %s to %s.\n", version, CurrentSaveVersion)
+	}
+
 	// Parse JSON
 	var saveState SaveState
 	err = json.Unmarshal(data, &saveState)
 	if err != nil {
 		return nil, fmt.Errorf("error parsing JSON: %v", err)
 	}
-
-	// Set the save file path for auto-save
-	saveState.CombatTracker.SaveFilePath = filename
-
-	// If no status effects are present, initialize with defaults
-	if len(saveState.CombatTracker.StatusEffects) == 0 {
-		// Define default status effects
-		defaultEffects := []string{
-			"Blinded",
-			"Charmed",
-			"Deafened",
-			"Frightened",
-			"Grappled",
-			"Incapacitated",
-			"Invisible",
-			"Paralyzed",
-			"Petrified",
-			"Poisoned",
-			"Prone",
-			"Restrained",
-			"Stunned",
-			"Unconscious",
-			"Custom Status Effect",
-		}
-		saveState.CombatTracker.StatusEffects = defaultEffects
-		fmt.Println("Initialized default status effects list.")
-	}
-
-	fmt.Printf("Loaded save from: %s\n", saveState.SaveTime)
-	return &saveState.CombatTracker, nil
+	return &saveState, nil
 }
 
 // SetEncounterDetails sets campaign and encounter names
 func (ct *CombatTracker) SetEncounterDetails(campaignName, encounterName string) {
 	ct.CampaignName = campaignName
 	ct.EncounterName = encounterName
-	fmt.Printf("Set encounter details - Campaign: %s, Encounter: %s\n", campaignName, encounterName)
+	ct.printf("Set encounter details - Campaign: %s, Encounter: %s\n", campaignName, encounterName)
 
 	// Auto-save state
 	ct.AutoSave()
@@ -427,75 +905,165 @@ func DisplayMenuHorizontal() {
 	fmt.Println("1:Add        2:Start     3:Next      4:HP         5:TempHP")
 	fmt.Println("6:AddStatus  7:RemStatus 8:Display   9:End       10:Details")
 	fmt.Println("11:Save      12:Load     13:Duplicate 14:Change Initiative")
-	fmt.Println("0:Exit")
+	fmt.Println("15:Group     16:Multi-HP 0:Exit")
 	fmt.Println("======================================================")
 }
 
-// DuplicateCombatant creates multiple copies of a combatant with incremented names
-func (ct *CombatTracker) DuplicateCombatant(index int, count int) {
+// DuplicateOptions controls how DuplicateCombatant builds its copies
+type DuplicateOptions struct {
+	Grouped         bool     // Copies share a single turn with the original
+	HitDice         string   // Formula to roll each copy's max HP from, empty copies the original's
+	RollInitiative  bool     // Roll a separate initiative for each copy
+	InitiativeBonus int      // Added to each rolled initiative
+	NameStyle       string   // One of the NameStyle constants, empty means numbers
+	Names           []string // Names handed out by NameStyleList
+}
+
+// DuplicateCombatant creates multiple copies of a combatant, naming them according to opts
+func (ct *CombatTracker) DuplicateCombatant(index int, count int, opts DuplicateOptions) {
 	if index < 0 || index >= len(ct.Combatants) {
-		fmt.Println("Invalid combatant index!")
+		ct.println("Invalid combatant index!")
 		return
 	}
 
+	var hitDice DiceFormula
+	if opts.HitDice != "" {
+		var err error
+		hitDice, err = ParseDice(opts.HitDice)
+		if err != nil {
+			ct.printf("Invalid hit dice: %v\n", err)
+			return
+		}
+	}
+
 	original := ct.Combatants[index]
-	baseName := original.Name
-
-	// Find the last number in the name if it exists
-	lastNumber := 0
-	if len(baseName) > 0 {
-		// Try to find a number at the end of the name
-		for i := len(baseName) - 1; i >= 0; i-- {
-			if baseName[i] >= '0' && baseName[i] <= '9' {
-				lastNumber = lastNumber*10 + int(baseName[i]-'0')
-			} else {
-				break
+
+	if opts.Grouped && original.Group == "" {
+		base, _, _ := splitNameNumber(original.Name)
+		original.Group = ct.newGroupName(strings.TrimSpace(base))
+		ct.Combatants[index].Group = original.Group
+		ct.printf("%s now leads the %s group\n", original.Name, original.Group)
+	}
+	if opts.Grouped && opts.RollInitiative {
+		ct.println("Group members share a turn, so copies keep the group's initiative.")
+	}
+
+	names := ct.copyNames(original.Name, count, opts)
+
+	// Create copies with the chosen names
+	for _, newName := range names {
+		maxHP := original.MaxHP
+		if opts.HitDice != "" {
+			maxHP, _ = hitDice.Roll()
+			if maxHP < 1 {
+				maxHP = 1
 			}
 		}
-		// Remove the number from the base name if it exists
-		if lastNumber > 0 {
-			baseName = strings.TrimRight(baseName, "0123456789")
+
+		initiative := original.Initiative
+		if opts.RollInitiative && !opts.Grouped {
+			initiative = rollDie(20) + opts.InitiativeBonus
 		}
-	}
 
-	// Create copies with incremented names
-	for i := 0; i < count; i++ {
-		newName := fmt.Sprintf("%s%d", baseName, lastNumber+i+1)
 		combatant := Combatant{
 			Name:          newName,
-			Initiative:    original.Initiative,
-			MaxHP:         original.MaxHP,
-			CurrentHP:     original.MaxHP,
+			Initiative:    initiative,
+			MaxHP:         maxHP,
+			CurrentHP:     maxHP,
 			IsPlayer:      original.IsPlayer,
+			Hidden:        original.Hidden,
+			CR:            original.CR,
+			XP:            original.XP,
+			Level:         original.Level,
+			AC:            original.AC,
 			IsConscious:   true,
 			TemporaryHP:   0,
 			StatusEffects: []string{},
 		}
+		if opts.Grouped {
+			combatant.Group = original.Group
+		}
 		ct.Combatants = append(ct.Combatants, combatant)
-		fmt.Printf("Created %s\n", newName)
+		ct.logStatsf(combatant.IsPlayer, "Created %s (Init: %d, HP: %d)", newName, initiative, maxHP)
+	}
+
+	// Keep the initiative order intact once combat is running
+	if ct.IsActive {
+		ct.resortKeepingTurn()
 	}
 
 	// Auto-save state
 	ct.AutoSave()
 }
 
+// newGroupName returns a group name based on base that isn't used by any combatant yet
+func (ct *CombatTracker) newGroupName(base string) string {
+	if base == "" {
+		base = "Group"
+	}
+
+	inUse := func(name string) bool {
+		for _, c := range ct.Combatants {
+			if c.Group == name {
+				return true
+			}
+		}
+		return false
+	}
+
+	name := base
+	for n := 2; inUse(name); n++ {
+		name = fmt.Sprintf("%s (%d)", base, n)
+	}
+	return name
+}
+
+// indexByName returns the index of the combatant with the given name, or -1
+func (ct *CombatTracker) indexByName(name string) int {
+	for i, c := range ct.Combatants {
+		if c.Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// slotStart returns the index of the first member of the turn slot containing index
+func (ct *CombatTracker) slotStart(index int) int {
+	if index < 0 {
+		return 0
+	}
+	for index > 0 && ct.inSameGroup(index, index-1) {
+		index--
+	}
+	return index
+}
+
 // ChangeInitiative updates a combatant's initiative value
 func (ct *CombatTracker) ChangeInitiative(index int, newInitiative int) {
 	if index < 0 || index >= len(ct.Combatants) {
-		fmt.Println("Invalid combatant index!")
+		ct.println("Invalid combatant index!")
 		return
 	}
 
 	c := &ct.Combatants[index]
 	oldInitiative := c.Initiative
-	c.Initiative = newInitiative
 
-	fmt.Printf("%s's initiative changed from %d to %d\n", c.Name, oldInitiative, newInitiative)
+	// Group members share a turn, so they always share an initiative
+	for _, m := range ct.groupMembers(index) {
+		ct.Combatants[m].Initiative = newInitiative
+	}
+
+	if c.Group != "" {
+		ct.logf("%s group's initiative changed from %d to %d", c.Group, oldInitiative, newInitiative)
+	} else {
+		ct.logf("%s's initiative changed from %d to %d", c.Name, oldInitiative, newInitiative)
+	}
 
 	// If combat is active, re-sort combatants
 	if ct.IsActive {
-		ct.SortByInitiative()
-		fmt.Println("Combat order updated.")
+		ct.resortKeepingTurn()
+		ct.println("Combat order updated.")
 	}
 
 	// Auto-save state
@@ -503,10 +1071,10 @@ func (ct *CombatTracker) ChangeInitiative(index int, newInitiative int) {
 }
 
 // getCurrentOrSelectedIndex gets the index of either the current player or a user-selected combatant
-func getCurrentOrSelectedIndex(ct *CombatTracker, scanner *bufio.Scanner, prompt string) (int, error) {
-	if ct.IsActive && ct.CurrentTurnIdx >= 0 {
-		currentPlayer := ct.Combatants[ct.CurrentTurnIdx]
-		fmt.Printf("Current player: %s (index: %d)\n", currentPlayer.Name, ct.CurrentTurnIdx+1)
+func getCurrentOrSelectedIndex(ct *CombatTracker, scanner lineScanner, prompt string) (int, error) {
+	hasTurn := ct.IsActive && ct.CurrentTurnIdx >= 0 && ct.CurrentTurnIdx < len(ct.Combatants)
+	if hasTurn {
+		fmt.Printf("Current player: %s (index: %d)\n", ct.turnName(ct.CurrentTurnIdx), ct.CurrentTurnIdx+1)
 	}
 
 	if prompt == "" {
@@ -517,8 +1085,33 @@ func getCurrentOrSelectedIndex(ct *CombatTracker, scanner *bufio.Scanner, prompt
 	indexStr := scanner.Text()
 
 	var index int
-	if indexStr == "" && ct.IsActive && ct.CurrentTurnIdx >= 0 {
+	if indexStr == "" && hasTurn {
 		index = ct.CurrentTurnIdx
+
+		// A group shares the turn, so ask which member is meant
+		members := ct.groupMembers(index)
+		if len(members) > 1 {
+			fmt.Printf("%s group members:\n", ct.Combatants[index].Group)
+			for _, m := range members {
+				fmt.Printf("%d. %s (HP: %d/%d)\n", m+1, ct.Combatants[m].Name, ct.Combatants[m].CurrentHP, ct.Combatants[m].MaxHP)
+			}
+			fmt.Printf("Enter member number (press Enter for %s): ", ct.Combatants[index].Name)
+			scanner.Scan()
+			if memberStr := scanner.Text(); memberStr != "" {
+				member, err := strconv.Atoi(memberStr)
+				if err != nil {
+					return -1, fmt.Errorf("invalid number entered")
+				}
+				inGroup := false
+				for _, m := range members {
+					inGroup = inGroup || m == member-1
+				}
+				if !inGroup {
+					return -1, fmt.Errorf("%d is not in the %s group", member, ct.Combatants[index].Group)
+				}
+				index = member - 1
+			}
+		}
 	} else {
 		var err error
 		index, err = strconv.Atoi(indexStr)
@@ -544,9 +1137,32 @@ func displayCommandHeader(title string) {
 func main() {
 	var ct *CombatTracker
 
-	// Check if a save file was provided as a command-line argument
+	// Subcommands work on a save file and exit without entering the menu
 	if len(os.Args) > 1 {
-		saveFilePath := os.Args[1]
+		if _, ok := subcommands[os.Args[1]]; ok {
+			os.Exit(runSubcommand(os.Args[1], os.Args[2:]))
+		}
+	}
+
+	// Lock files are taken when a save file is first written
+	defer releaseSaveLocks()
+
+	tuiMode := flag.Bool("tui", false, "start in the full-screen interface")
+	serveAddr := flag.String("serve", "", "serve the encounter as JSON on this address, e.g. :8080")
+	showMonsterHP := flag.Bool("show-hp", false, "show monster HP to players on the web server")
+	flag.StringVar(&partyFile, "party", "", "party file (default $"+partyFileEnv+" or party.json beside the save file)")
+	flag.StringVar(&encounterLibrary, "encounters", "", "encounter library directory (default $"+encounterLibraryEnv+" or ./encounters)")
+	flag.Usage = func() {
+		printSubcommandUsage(flag.CommandLine.Output())
+		fmt.Fprintln(flag.CommandLine.Output(), "\nOptions:")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	// Check if a save file was provided as a command-line argument
+	if flag.NArg() > 0 {
+		saveFilePath := flag.Arg(0)
+		partyBeside(saveFilePath)
 		var err error
 
 		// Try to load the file
@@ -555,30 +1171,72 @@ func main() {
 			fmt.Printf("Failed to load save file: %v\n", err)
 			fmt.Println("Creating a new combat tracker instead.")
 			ct = NewCombatTracker()
-			ct.SaveFilePath = saveFilePath // Set for future auto-saves
+			if _, statErr := os.Stat(saveFilePath); os.IsNotExist(statErr) {
+				ct.SaveFilePath = saveFilePath // Set for future auto-saves
+			} else {
+				// Don't overwrite a save this version can't read
+				fmt.Printf("Auto-save disabled so %s isn't overwritten.\n", saveFilePath)
+			}
 		}
 	} else {
 		// No save file provided, start fresh
 		ct = NewCombatTracker()
 	}
 
-	scanner := bufio.NewScanner(os.Stdin)
+	// Player updates arrive from the web server while the menu runs, so the tracker
+	// is only touched with the session held. It's released while waiting for input.
+	session := NewSession(ct)
+	scanner := sessionInput{Input: stdin, session: session}
+
+	// Use the line editor for the main prompt when talking to a terminal
+	var editor *LineEditor
+	if isTerminal(os.Stdin.Fd()) {
+		editor = NewLineEditor(defaultHistoryPath(), trackerCompleter(session.Snapshot))
+	}
 
 	fmt.Println("===== D&D COMBAT TRACKER =====")
 	if ct.SaveFilePath != "" {
-		fmt.Printf("Auto-saving enabled to: %s\n", ct.SaveFilePath)
+		// Claim the save file now rather than failing on the first auto-save
+		if err := lockSaveFile(ct.SaveFilePath); err != nil {
+			fmt.Printf("Auto-save disabled: %v\n", err)
+			ct.SaveFilePath = ""
+		} else {
+			fmt.Printf("Auto-saving enabled to: %s\n", ct.SaveFilePath)
+		}
+	}
+
+	if *serveAddr != "" {
+		server := NewServer(*showMonsterHP)
+		server.EnableWrites(session)
+		if err := server.Start(*serveAddr); err != nil {
+			fmt.Println(err)
+		} else {
+			ct.SetOnChange(server.Publish)
+		}
+	}
+
+	if *tuiMode {
+		if err := RunTUI(session, editor); err != nil {
+			fmt.Printf("Could not start the full-screen interface: %v\n", err)
+		} else {
+			fmt.Println("Left the full-screen interface, type tui to go back.")
+		}
 	}
 
+	session.Lock()
 	for {
+		// Let listeners such as the web server see the result of the last command
+		ct.changed()
+
 		// Always display the current combat state
 		ct.DisplayCombatState()
 
 		// Display menu options horizontally
 		DisplayMenuHorizontal()
 
-		fmt.Print("\nEnter command: ")
-		scanner.Scan()
-		cmd := scanner.Text()
+		session.Unlock()
+		cmd := readCommand(editor, stdin, "\nEnter command (number, or type help): ")
+		session.Lock()
 
 		ClearScreen() // Clear screen before processing command
 
@@ -652,34 +1310,15 @@ func main() {
 
 			fmt.Println("=== SAVE COMBAT STATE ===")
 
-			defaultFilename := ct.SaveFilePath
-			if defaultFilename == "" {
-				// Generate a default filename with timestamp
-				defaultFilename = fmt.Sprintf("combat_%s_%s_%s.json",
-					strings.ReplaceAll(ct.CampaignName, " ", "_"),
-					strings.ReplaceAll(ct.EncounterName, " ", "_"),
-					time.Now().Format("2006-01-02_15-04-05"))
-			}
+			defaultFilename := ct.defaultSaveFilename()
 
 			fmt.Printf("Enter filename (default: %s): ", defaultFilename)
 			scanner.Scan()
 			filename = scanner.Text()
 
-			if filename == "" {
-				filename = defaultFilename
-			}
-
-			// Add .json extension if not present
-			if !strings.HasSuffix(filename, ".json") {
-				filename += ".json"
-			}
-
-			err := ct.SaveToFile(filename)
+			err := ct.SaveAs(filename)
 			if err != nil {
 				fmt.Printf("Error saving: %v\n", err)
-			} else {
-				// Update the save file path for auto-saves
-				ct.SaveFilePath = filename
 			}
 
 		case "12": // Load Combat State
@@ -694,7 +1333,7 @@ func main() {
 			if err != nil {
 				fmt.Printf("Error loading: %v\n", err)
 			} else {
-				ct = loadedCT
+				ct.replaceWith(loadedCT)
 				fmt.Println("Combat state loaded successfully!")
 			}
 
@@ -704,7 +1343,21 @@ func main() {
 		case "14": // Change Initiative
 			handleChangeInitiative(ct, scanner)
 
-		case "0": // Exit
+		case "15": // Collapse/Expand Group
+			handleToggleGroup(ct, scanner)
+
+		case "16": // Damage/Heal Several Combatants
+			handleAdjustHPMultiple(ct, scanner)
+
+		case "tui": // Full-screen interface
+			session.Unlock()
+			err := RunTUI(session, editor)
+			session.Lock()
+			if err != nil {
+				fmt.Printf("Could not start the full-screen interface: %v\n", err)
+			}
+
+		case "0", "quit", "exit": // Exit
 			fmt.Println("Exiting D&D Combat Tracker. Farewell, adventurer!")
 
 			// Perform one final auto-save before exiting
@@ -715,13 +1368,39 @@ func main() {
 
 			return
 
-		default:
-			fmt.Println("Invalid command. Please try again.")
+		default: // One-line command such as "dmg goblin2 12 fire"
+			if err := ct.ExecuteCommand(cmd); err != nil {
+				fmt.Println(err)
+			}
+		}
+	}
+}
+
+// readCommand reads the next command from the line editor when there is one, or
+// from scanner otherwise. End of input reads as the exit command.
+func readCommand(editor *LineEditor, scanner lineScanner, prompt string) string {
+	if editor != nil {
+		line, err := editor.ReadLine(prompt)
+		if err == errInterrupted {
+			return ""
+		}
+		if err == nil {
+			return strings.TrimSpace(line)
 		}
+		if err == io.EOF {
+			return "0"
+		}
+		// Raw mode isn't available after all, carry on without the editor
+	}
+
+	fmt.Print(prompt)
+	if !scanner.Scan() {
+		return "0"
 	}
+	return strings.TrimSpace(scanner.Text())
 }
 
-func handleAdjustHP(ct *CombatTracker, scanner *bufio.Scanner) {
+func handleAdjustHP(ct *CombatTracker, scanner lineScanner) {
 	displayCommandHeader("Adjust Hit Points")
 	ct.DisplayCombatState()
 
@@ -742,7 +1421,7 @@ func handleAdjustHP(ct *CombatTracker, scanner *bufio.Scanner) {
 	ct.AdjustHP(index, amount)
 }
 
-func handleAddTempHP(ct *CombatTracker, scanner *bufio.Scanner) {
+func handleAddTempHP(ct *CombatTracker, scanner lineScanner) {
 	displayCommandHeader("Add Temporary HP")
 	ct.DisplayCombatState()
 
@@ -763,7 +1442,7 @@ func handleAddTempHP(ct *CombatTracker, scanner *bufio.Scanner) {
 	ct.AddTemporaryHP(index, amount)
 }
 
-func handleAddStatusEffect(ct *CombatTracker, scanner *bufio.Scanner) {
+func handleAddStatusEffect(ct *CombatTracker, scanner lineScanner) {
 	displayCommandHeader("Add Status Effect")
 	ct.DisplayCombatState()
 
@@ -799,7 +1478,7 @@ func handleAddStatusEffect(ct *CombatTracker, scanner *bufio.Scanner) {
 	ct.AddStatusEffect(index, effect)
 }
 
-func handleRemoveStatusEffect(ct *CombatTracker, scanner *bufio.Scanner) {
+func handleRemoveStatusEffect(ct *CombatTracker, scanner lineScanner) {
 	displayCommandHeader("Remove Status Effect")
 	ct.DisplayCombatState()
 
@@ -832,7 +1511,7 @@ func handleRemoveStatusEffect(ct *CombatTracker, scanner *bufio.Scanner) {
 	ct.RemoveStatusEffect(index, effect)
 }
 
-func handleDuplicateCombatant(ct *CombatTracker, scanner *bufio.Scanner) {
+func handleDuplicateCombatant(ct *CombatTracker, scanner lineScanner) {
 	displayCommandHeader("Duplicate Combatant")
 	ct.DisplayCombatState()
 
@@ -850,10 +1529,59 @@ func handleDuplicateCombatant(ct *CombatTracker, scanner *bufio.Scanner) {
 		return
 	}
 
-	ct.DuplicateCombatant(index, count)
+	var opts DuplicateOptions
+
+	fmt.Print("Share a single group turn? (y/n): ")
+	scanner.Scan()
+	grouped := strings.ToLower(scanner.Text())
+	opts.Grouped = grouped == "y" || grouped == "yes"
+
+	fmt.Print("Hit dice to roll HP from (e.g. 2d6, press Enter to copy max HP): ")
+	scanner.Scan()
+	opts.HitDice = strings.TrimSpace(scanner.Text())
+
+	if !opts.Grouped {
+		fmt.Print("Initiative bonus to roll each copy's initiative with (press Enter to copy initiative): ")
+		scanner.Scan()
+		if bonusStr := strings.TrimSpace(scanner.Text()); bonusStr != "" {
+			bonus, err := strconv.Atoi(bonusStr)
+			if err != nil {
+				fmt.Println("Invalid initiative bonus!")
+				return
+			}
+			opts.RollInitiative = true
+			opts.InitiativeBonus = bonus
+		}
+	}
+
+	fmt.Println("Name style: 1. Numbers (Goblin2)  2. Letters (Goblin B)  3. Adjectives (Scarred Goblin)  4. Your own list")
+	fmt.Print("Enter name style (press Enter for numbers): ")
+	scanner.Scan()
+	switch strings.TrimSpace(scanner.Text()) {
+	case "", "1":
+		opts.NameStyle = NameStyleNumbers
+	case "2":
+		opts.NameStyle = NameStyleLetters
+	case "3":
+		opts.NameStyle = NameStyleAdjectives
+	case "4":
+		opts.NameStyle = NameStyleList
+		fmt.Print("Enter names separated by commas: ")
+		scanner.Scan()
+		for _, name := range strings.Split(scanner.Text(), ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				opts.Names = append(opts.Names, name)
+			}
+		}
+	default:
+		fmt.Println("Invalid name style!")
+		return
+	}
+
+	ct.DuplicateCombatant(index, count, opts)
 }
 
-func handleChangeInitiative(ct *CombatTracker, scanner *bufio.Scanner) {
+func handleChangeInitiative(ct *CombatTracker, scanner lineScanner) {
 	displayCommandHeader("Change Initiative")
 	ct.DisplayCombatState()
 
@@ -873,3 +1601,90 @@ func handleChangeInitiative(ct *CombatTracker, scanner *bufio.Scanner) {
 
 	ct.ChangeInitiative(index, newInitiative)
 }
+
+func handleToggleGroup(ct *CombatTracker, scanner lineScanner) {
+	displayCommandHeader("Collapse/Expand Group")
+	ct.DisplayCombatState()
+
+	index, err := getCurrentOrSelectedIndex(ct, scanner, "Enter number of any group member: ")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	ct.ToggleGroupCollapsed(index)
+}
+
+func handleAdjustHPMultiple(ct *CombatTracker, scanner lineScanner) {
+	displayCommandHeader("Adjust Hit Points of Several Combatants")
+	ct.DisplayCombatState()
+
+	fmt.Print("Enter targets (e.g. 1,3 or 2-5 or gob* or all monsters): ")
+	scanner.Scan()
+	indices, err := ct.ResolveTargets(scanner.Text())
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	names := make([]string, len(indices))
+	for i, index := range indices {
+		names[i] = ct.Combatants[index].Name
+	}
+	fmt.Printf("Targets: %s\n", strings.Join(names, ", "))
+
+	fmt.Print("Enter amount (+heal, -damage): ")
+	scanner.Scan()
+	amount, err := strconv.Atoi(scanner.Text())
+	if err != nil {
+		fmt.Println("Invalid amount entered")
+		return
+	}
+
+	halved := make(map[int]bool)
+	if amount < 0 {
+		fmt.Print("Half damage on a save? (n: no save, e: enter results, r: roll saves): ")
+		scanner.Scan()
+		switch strings.ToLower(strings.TrimSpace(scanner.Text())) {
+		case "", "n", "no":
+
+		case "e":
+			for _, index := range indices {
+				fmt.Printf("Did %s save? (y/n): ", ct.Combatants[index].Name)
+				scanner.Scan()
+				answer := strings.ToLower(scanner.Text())
+				halved[index] = answer == "y" || answer == "yes"
+			}
+
+		case "r":
+			fmt.Print("Enter save DC: ")
+			scanner.Scan()
+			dc, err := strconv.Atoi(scanner.Text())
+			if err != nil {
+				fmt.Println("Invalid DC entered")
+				return
+			}
+			for _, index := range indices {
+				fmt.Printf("Save bonus for %s: ", ct.Combatants[index].Name)
+				scanner.Scan()
+				bonus, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+				if err != nil {
+					bonus = 0
+				}
+				roll, saved := RollSave(bonus, dc)
+				halved[index] = saved
+				result := "fails"
+				if saved {
+					result = "saves"
+				}
+				fmt.Printf("%s rolls %d vs DC %d and %s\n", ct.Combatants[index].Name, roll, dc, result)
+			}
+
+		default:
+			fmt.Println("Invalid selection!")
+			return
+		}
+	}
+
+	ct.AdjustHPMultiple(indices, amount, halved, "")
+}
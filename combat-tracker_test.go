@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestResortKeepsTurnWithSameNames(t *testing.T) {
+	ct, _ := newTestTracker(t)
+	ct.AddCombatant("Goblin", 20, 7, false)
+	ct.AddCombatant("Thorin", 15, 30, true)
+	ct.AddCombatant("Goblin", 5, 7, false)
+	ct.StartCombat()
+	ct.NextTurn()
+	ct.NextTurn()
+	if c := ct.Combatants[ct.CurrentTurnIdx]; c.Name != "Goblin" || c.Initiative != 5 {
+		t.Fatalf("expected the second Goblin's turn, got %s (%d)", c.Name, c.Initiative)
+	}
+
+	ct.ChangeInitiative(ct.indexByName("Thorin"), 1)
+	if c := ct.Combatants[ct.CurrentTurnIdx]; c.Name != "Goblin" || c.Initiative != 5 {
+		t.Errorf("changing Thorin's initiative moved the turn to %s (%d)", c.Name, c.Initiative)
+	}
+
+	ct.DuplicateCombatant(ct.indexByName("Thorin"), 1, DuplicateOptions{})
+	if c := ct.Combatants[ct.CurrentTurnIdx]; c.Name != "Goblin" || c.Initiative != 5 {
+		t.Errorf("duplicating Thorin moved the turn to %s (%d)", c.Name, c.Initiative)
+	}
+}
+
+func TestSaveAndLogUseClock(t *testing.T) {
+	ct, _ := newTestTracker(t)
+	ct.AddCombatant("Thorin", 15, 30, true)
+	filename := filepath.Join(t.TempDir(), "save.json")
+	if err := ct.SaveToFile(filename); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var saved SaveState
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatal(err)
+	}
+	want := clockNow().Format(time.RFC3339)
+	if saved.SaveTime != want {
+		t.Errorf("save time is %s, expected the clock's %s", saved.SaveTime, want)
+	}
+	if len(saved.CombatTracker.Log) == 0 || saved.CombatTracker.Log[0].Time != want {
+		t.Errorf("log entries don't use the clock: %+v", saved.CombatTracker.Log)
+	}
+}
+
+func TestDamageTypeIsLogged(t *testing.T) {
+	ct, _ := newTestTracker(t)
+	ct.AddCombatant("Thorin", 15, 30, true)
+	ct.AddCombatant("Orc", 10, 40, false)
+	ct.SetAlias(ct.indexByName("Orc"), "Brute")
+	ct.SetArmorClass([]int{ct.indexByName("Orc")}, 13)
+
+	// Every die rolls its highest, so the attack is a critical hit
+	previous := rollDie
+	rollDie = func(sides int) int { return sides }
+	t.Cleanup(func() { rollDie = previous })
+
+	damage, err := ParseDice("1d8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct.Attack(ct.indexByName("Thorin"), ct.indexByName("Orc"), AttackOptions{Bonus: 5, Damage: damage, DamageType: "slashing"})
+	if err := cmdDamage(ct, []string{"thorin", "4", "fire"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var messages []string
+	for _, entry := range ct.playerLog(false) {
+		messages = append(messages, entry.Message)
+	}
+	for _, want := range []string{"Brute takes 16 slashing damage", "Thorin takes 4 fire damage"} {
+		if !slices.Contains(messages, want) {
+			t.Errorf("player log is missing %q: %q", want, messages)
+		}
+	}
+}
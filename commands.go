@@ -0,0 +1,664 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// commandFunc runs one command of the command language with its arguments
+type commandFunc func(ct *CombatTracker, args []string) error
+
+// commands maps each command word of the command language to its implementation
+var commands = map[string]commandFunc{
+	"add":        cmdAdd,
+	"start":      cmdStart,
+	"next":       cmdNext,
+	"end":        cmdEnd,
+	"dmg":        cmdDamage,
+	"heal":       cmdHeal,
+	"temp":       cmdTemp,
+	"cond":       cmdCondition,
+	"dup":        cmdDuplicate,
+	"init":       cmdInitiative,
+	"group":      cmdGroup,
+	"details":    cmdDetails,
+	"save":       cmdSave,
+	"load":       cmdLoad,
+	"show":       cmdShow,
+	"record":     cmdRecord,
+	"rolls":      cmdRolls,
+	"token":      cmdToken,
+	"backups":    cmdBackups,
+	"preview":    cmdPreview,
+	"restore":    cmdRestore,
+	"hide":       cmdHide,
+	"reveal":     cmdReveal,
+	"alias":      cmdAlias,
+	"approval":   cmdApproval,
+	"pending":    cmdPending,
+	"approve":    cmdApprove,
+	"reject":     cmdReject,
+	"encounter":  cmdEncounter,
+	"party":      cmdParty,
+	"rest":       cmdRest,
+	"use":        cmdUse,
+	"cr":         cmdCR,
+	"level":      cmdLevel,
+	"difficulty": cmdDifficulty,
+	"loot":       cmdLoot,
+	"xpsplit":    cmdXPSplit,
+	"stats":      cmdStats,
+	"timer":      cmdTimer,
+	"attack":     cmdAttack,
+	"ac":         cmdAC,
+	"help":       cmdHelp,
+}
+
+// commandAliases maps alternative spellings onto command words
+var commandAliases = map[string]string{
+	"damage":    "dmg",
+	"hp":        "dmg",
+	"condition": "cond",
+	"status":    "cond",
+	"duplicate": "dup",
+	"n":         "next",
+	"state":     "show",
+	"?":         "help",
+	"replay":    "run",
+	"enc":       "encounter",
+	"lvl":       "level",
+	"diff":      "difficulty",
+}
+
+// commandHelp describes the command language
+const commandHelp = `Commands (a target is a combatant number or the start of a name):
+  add <name> <init> <hp> [pc]         add a combatant, init and hp may be dice (d20+2, 2d8+4)
+                                      with cr=<cr> or level=<n> to rate the encounter
+  start | next | end                  start combat, advance the turn, end combat
+  dmg <targets> <amount> [type]       damage, e.g. "dmg goblin2 12 fire" or "dmg gob* 8d6"
+                                      end with save <DC> [+bonus] to roll saves for half damage,
+                                      or half <targets> for the ones that saved
+  heal <targets> <amount>             heal, e.g. "heal thorin 2d4+2"
+  temp <target> <amount>              give temporary HP
+  cond <target> +<effect> [<n>r]      add an effect, optionally for n rounds: "cond orc +prone 1r"
+  cond <target> -<effect>             remove an effect
+  dup <target> <count> [group]        duplicate a combatant, optionally sharing a group turn
+  init <target> <value>               change initiative
+  group <target>                      collapse or expand a group
+  hide <targets> | reveal <targets>   hide combatants from players, or reveal them
+                                      ("add ... hidden" adds a combatant already hidden)
+  alias <target> "<name>" | off       show players another name, e.g. "Hooded Figure"
+  details "<campaign>" "<encounter>"  set campaign and encounter names
+  encounter list | preview <name>     list or show prepared encounters from the library
+  encounter launch <name>             set up a prepared encounter against the party
+  encounter create "<name>" ["<text>"]  save the current monsters as a prepared encounter
+  cr <targets> <cr> | level <targets> <n>   set monster challenge ratings and player levels
+  difficulty                          show how hard the encounter is for the party
+  loot [<target> "<item>" | clear]    list loot, or attach a note handed out when combat ends
+  xpsplit all|survivors               split XP between every player or only those standing
+  stats [md|csv [file] [gm]]          show or export damage, healing, turns and downs this combat
+  timer                               show the turn and round timers and time spent per combatant
+  timer limit <time>|off              warn when a turn runs over, e.g. "timer limit 1m30s"
+  timer budget <targets> <time>|off   give combatants their own turn limit
+  timer reset                         clear the time spent totals
+  ac <targets> <AC>                   set armor class, e.g. "ac goblin1-3 15"
+  attack <attacker> <target> <bonus> <damage> [type] [adv|dis] [ranged]
+                                      roll to hit against AC and apply the damage,
+                                      e.g. "attack thorin goblin1 +5 1d8+3 slashing"
+  party                               show the party with hit dice and resources
+  party add <targets> | remove <name> add player characters to the party file, or remove one
+  party hitdice <name> <count> <die>  set hit dice, e.g. "party hitdice thorin 5 d10+2"
+  party resource <name> "<resource>" <max> short|long   track a resource such as ki
+  use <name> "<resource>" [uses]      spend uses of a resource
+  rest short [<name> <dice>]...       short rest, spending hit dice: "rest short thorin 2"
+  rest long                           long rest: full HP, half the hit dice, every resource
+  save [file] | load <file>           save or load the combat state
+  backups                             list backups of the save file by round, turn and time
+  preview <backup> | restore <backup> show or go back to a backup, e.g. "restore r3"
+  show                                redisplay the combat state
+  run <script>                        run the commands in a script file
+  record <file> | record off          append every successful command to a file
+  rolls <result...>                   use these dice results for the next command, as recordings do
+  token [<target> [off]]              list, create or revoke player API tokens
+  approval on|off                     make player updates wait for the GM
+  pending | approve <n|all> | reject <n|all>   review waiting player updates
+  help                                show this help
+Damage and healing accept several targets: "1,3", "2-5", "goblin1-3", "gob*" or "monsters".
+Names with spaces can be quoted. Numbers 0-16 still open the step-by-step menu.`
+
+// commandWords returns every command word and alias, sorted
+func commandWords() []string {
+	words := make([]string, 0, len(commands)+len(commandAliases))
+	for word := range commands {
+		words = append(words, word)
+	}
+	for alias := range commandAliases {
+		words = append(words, alias)
+	}
+	sort.Strings(words)
+	return words
+}
+
+// ExecuteCommand runs a single line of the command language, such as "dmg goblin2 12 fire"
+func (ct *CombatTracker) ExecuteCommand(line string) error {
+	args, err := splitCommandLine(line)
+	if err != nil {
+		return err
+	}
+	if len(args) == 0 {
+		return nil
+	}
+
+	verb := strings.ToLower(args[0])
+	if alias, ok := commandAliases[verb]; ok {
+		verb = alias
+	}
+
+	cmd, ok := commands[verb]
+	if !ok {
+		return fmt.Errorf("unknown command %q, type help for a list of commands", args[0])
+	}
+	rolled := ct.captureRolls()
+	err = cmd(ct, args[1:])
+	rolls, mismatch := rolled()
+	if err != nil {
+		return err
+	}
+	if mismatch != nil {
+		return mismatch
+	}
+
+	// Scripts record the commands they run, not themselves
+	if verb != "record" && verb != "run" && verb != "rolls" {
+		ct.recordCommand(line, rolls)
+	}
+	return nil
+}
+
+// splitCommandLine splits a command line into words, keeping double-quoted text together
+func splitCommandLine(line string) ([]string, error) {
+	var args []string
+	var current strings.Builder
+	inQuotes, inWord := false, false
+
+	for _, r := range line {
+		switch {
+		case r == '"':
+			inQuotes = !inQuotes
+			inWord = true
+		case (r == ' ' || r == '\t') && !inQuotes:
+			if inWord {
+				args = append(args, current.String())
+				current.Reset()
+				inWord = false
+			}
+		default:
+			current.WriteRune(r)
+			inWord = true
+		}
+	}
+
+	if inQuotes {
+		return nil, fmt.Errorf("unterminated quote in command")
+	}
+	if inWord {
+		args = append(args, current.String())
+	}
+	return args, nil
+}
+
+// ResolveTarget finds a single combatant by number or by name. An exact name
+// wins, otherwise the name prefix must match exactly one combatant.
+func (ct *CombatTracker) ResolveTarget(target string) (int, error) {
+	if n, err := strconv.Atoi(target); err == nil {
+		if n < 1 || n > len(ct.Combatants) {
+			return -1, fmt.Errorf("no combatant number %d", n)
+		}
+		return n - 1, nil
+	}
+
+	lower := strings.ToLower(target)
+	matches := []int{}
+	for i, c := range ct.Combatants {
+		name := strings.ToLower(c.Name)
+		if name == lower {
+			return i, nil
+		}
+		if strings.HasPrefix(name, lower) {
+			matches = append(matches, i)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return -1, fmt.Errorf("no combatant named %q", target)
+	case 1:
+		return matches[0], nil
+	default:
+		names := make([]string, len(matches))
+		for i, m := range matches {
+			names[i] = ct.Combatants[m].Name
+		}
+		return -1, fmt.Errorf("%q could be %s", target, strings.Join(names, ", "))
+	}
+}
+
+// resolveTargetList resolves either a single target or a multi-target selector
+func (ct *CombatTracker) resolveTargetList(target string) ([]int, error) {
+	lower := strings.ToLower(target)
+	if strings.ContainsAny(target, ",*?[") || lower == "all" || lower == "monsters" || lower == "players" {
+		return ct.ResolveTargets(target)
+	}
+	if from, to, ok := strings.Cut(target, "-"); ok {
+		if _, err := strconv.Atoi(from); err == nil {
+			if _, err := strconv.Atoi(to); err == nil {
+				return ct.ResolveTargets(target)
+			}
+		}
+	}
+	if _, _, _, ok := nameRange(lower); ok {
+		return ct.ResolveTargets(target)
+	}
+
+	index, err := ct.ResolveTarget(target)
+	if err != nil {
+		return nil, err
+	}
+	return []int{index}, nil
+}
+
+// parseAmount reads a number or rolls a dice expression such as "2d4+2"
+func (ct *CombatTracker) parseAmount(s string) (int, error) {
+	if n, err := strconv.Atoi(s); err == nil {
+		return n, nil
+	}
+
+	formula, err := ParseDice(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	total, rolls := formula.Roll()
+	ct.printf("Rolled %s: %d %v\n", formula, total, rolls)
+	return total, nil
+}
+
+// parseRounds reads a duration such as "3r", "3rounds" or "3"
+func parseRounds(s string) (int, bool) {
+	s = strings.ToLower(s)
+	s = strings.TrimSuffix(s, "rounds")
+	s = strings.TrimSuffix(s, "round")
+	s = strings.TrimSuffix(s, "r")
+	rounds, err := strconv.Atoi(s)
+	if err != nil || rounds < 1 {
+		return 0, false
+	}
+	return rounds, true
+}
+
+// catalogEffect returns the status effect from the tracker's catalog matching name,
+// or name itself when it isn't in the catalog
+func (ct *CombatTracker) catalogEffect(name string) string {
+	lower := strings.ToLower(name)
+	for _, effect := range ct.StatusEffects {
+		if strings.ToLower(effect) == lower {
+			return effect
+		}
+	}
+	for _, effect := range ct.StatusEffects {
+		if strings.HasPrefix(strings.ToLower(effect), lower) {
+			return effect
+		}
+	}
+	return name
+}
+
+func cmdAdd(ct *CombatTracker, args []string) error {
+	if len(args) < 3 {
+		return fmt.Errorf("usage: add <name> <init> <hp> [pc] [hidden]")
+	}
+
+	initiative, err := ct.parseAmount(args[1])
+	if err != nil {
+		return err
+	}
+	hp, err := ct.parseAmount(args[2])
+	if err != nil {
+		return err
+	}
+
+	isPlayer, hidden := false, false
+	cr, level := "", 0
+	for _, option := range args[3:] {
+		switch strings.ToLower(option) {
+		case "pc", "player", "p", "y", "yes":
+			isPlayer = true
+		case "monster", "npc", "m", "n", "no":
+		case "hidden":
+			hidden = true
+		default:
+			key, value, ok := strings.Cut(strings.ToLower(option), "=")
+			switch {
+			case ok && key == "cr":
+				if cr, err = normalizeCR(value); err != nil {
+					return err
+				}
+			case ok && (key == "level" || key == "lvl"):
+				if level, err = strconv.Atoi(value); err != nil || level < 1 || level >= len(xpThresholds) {
+					return fmt.Errorf("level must be 1 to %d", len(xpThresholds)-1)
+				}
+			default:
+				return fmt.Errorf("expected pc, monster, hidden, cr=<cr> or level=<n>, got %q", option)
+			}
+		}
+	}
+
+	ct.AddCombatant(args[0], initiative, hp, isPlayer)
+	ct.printf("Added %s to combat with initiative %d and %d HP\n", args[0], initiative, hp)
+	if cr != "" {
+		ct.SetChallengeRating([]int{len(ct.Combatants) - 1}, cr)
+	}
+	if level > 0 {
+		ct.SetLevel([]int{len(ct.Combatants) - 1}, level)
+	}
+	if hidden {
+		ct.SetHidden([]int{len(ct.Combatants) - 1}, true)
+	}
+	return nil
+}
+
+func cmdStart(ct *CombatTracker, args []string) error {
+	if len(ct.Combatants) == 0 {
+		return fmt.Errorf("cannot start combat with no combatants")
+	}
+	ct.StartCombat()
+	return nil
+}
+
+func cmdNext(ct *CombatTracker, args []string) error {
+	if !ct.IsActive {
+		return fmt.Errorf("combat hasn't started yet")
+	}
+	ct.NextTurn()
+	return nil
+}
+
+func cmdEnd(ct *CombatTracker, args []string) error {
+	if !ct.IsActive {
+		return fmt.Errorf("no active combat to end")
+	}
+	ct.EndCombat()
+	return nil
+}
+
+func cmdDamage(ct *CombatTracker, args []string) error {
+	usage := fmt.Errorf("usage: dmg <targets> <amount> [type] [save <DC> [+bonus] | half <targets>]")
+	if len(args) < 2 {
+		return usage
+	}
+
+	indices, err := ct.resolveTargetList(args[0])
+	if err != nil {
+		return err
+	}
+	amount, err := ct.parseAmount(args[1])
+	if err != nil {
+		return err
+	}
+	if amount < 0 {
+		amount = -amount
+	}
+
+	// Targets that make their save take half: rolled against a DC, or named
+	var halved map[int]bool
+	damageType := []string{}
+	dc, bonus, rollSaves := 0, 0, false
+	for i := 2; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "save":
+			if halved != nil || rollSaves || i+1 >= len(args) {
+				return usage
+			}
+			i++
+			if dc, err = strconv.Atoi(args[i]); err != nil {
+				return fmt.Errorf("invalid save DC %q", args[i])
+			}
+			if i+1 < len(args) && (strings.HasPrefix(args[i+1], "+") || strings.HasPrefix(args[i+1], "-")) {
+				i++
+				if bonus, err = strconv.Atoi(args[i]); err != nil {
+					return fmt.Errorf("invalid save bonus %q, e.g. +2", args[i])
+				}
+			}
+			rollSaves = true
+		case "half":
+			if halved != nil || rollSaves || i+1 >= len(args) {
+				return usage
+			}
+			i++
+			saved, err := ct.resolveTargetList(args[i])
+			if err != nil {
+				return err
+			}
+			targeted := make(map[int]bool)
+			for _, index := range indices {
+				targeted[index] = true
+			}
+			halved = make(map[int]bool)
+			for _, index := range saved {
+				if !targeted[index] {
+					return fmt.Errorf("%s isn't one of the targets", ct.Combatants[index].Name)
+				}
+				halved[index] = true
+			}
+		default:
+			damageType = append(damageType, strings.ToLower(args[i]))
+		}
+	}
+
+	if rollSaves {
+		halved = make(map[int]bool)
+		for _, index := range indices {
+			roll, saved := RollSave(bonus, dc)
+			halved[index] = saved
+			result := "fails"
+			if saved {
+				result = "saves"
+			}
+			ct.printf("%s rolls %d vs DC %d and %s\n", ct.Combatants[index].Name, roll, dc, result)
+		}
+	}
+
+	ct.AdjustHPMultiple(indices, -amount, halved, strings.Join(damageType, " "))
+	return nil
+}
+
+func cmdHeal(ct *CombatTracker, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: heal <targets> <amount>")
+	}
+
+	indices, err := ct.resolveTargetList(args[0])
+	if err != nil {
+		return err
+	}
+	amount, err := ct.parseAmount(args[1])
+	if err != nil {
+		return err
+	}
+	if amount < 0 {
+		amount = -amount
+	}
+
+	ct.AdjustHPMultiple(indices, amount, nil, "")
+	return nil
+}
+
+func cmdTemp(ct *CombatTracker, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: temp <target> <amount>")
+	}
+
+	index, err := ct.ResolveTarget(args[0])
+	if err != nil {
+		return err
+	}
+	amount, err := ct.parseAmount(args[1])
+	if err != nil {
+		return err
+	}
+
+	ct.AddTemporaryHP(index, amount)
+	return nil
+}
+
+func cmdCondition(ct *CombatTracker, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: cond <target> +<effect> [<n>r] | -<effect>")
+	}
+
+	index, err := ct.ResolveTarget(args[0])
+	if err != nil {
+		return err
+	}
+
+	for i := 1; i < len(args); i++ {
+		arg := args[i]
+		if len(arg) < 2 || (arg[0] != '+' && arg[0] != '-') {
+			return fmt.Errorf("expected +<effect> or -<effect>, got %q", arg)
+		}
+		effect := ct.catalogEffect(arg[1:])
+
+		if arg[0] == '-' {
+			ct.RemoveStatusEffect(index, ct.appliedEffect(index, effect))
+			continue
+		}
+
+		// A following duration applies to this effect
+		if i+1 < len(args) {
+			if rounds, ok := parseRounds(args[i+1]); ok {
+				ct.AddTimedStatusEffect(index, effect, rounds)
+				i++
+				continue
+			}
+		}
+		ct.AddStatusEffect(index, effect)
+	}
+	return nil
+}
+
+// appliedEffect returns the spelling of effect as it appears on the combatant
+func (ct *CombatTracker) appliedEffect(index int, effect string) string {
+	for _, e := range ct.Combatants[index].StatusEffects {
+		if strings.EqualFold(e, effect) {
+			return e
+		}
+	}
+	return effect
+}
+
+func cmdDuplicate(ct *CombatTracker, args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return fmt.Errorf("usage: dup <target> <count> [group]")
+	}
+
+	index, err := ct.ResolveTarget(args[0])
+	if err != nil {
+		return err
+	}
+	count, err := strconv.Atoi(args[1])
+	if err != nil || count < 1 {
+		return fmt.Errorf("invalid number of copies %q", args[1])
+	}
+
+	opts := DuplicateOptions{}
+	if len(args) == 3 {
+		if strings.ToLower(args[2]) != "group" {
+			return fmt.Errorf("expected group, got %q", args[2])
+		}
+		opts.Grouped = true
+	}
+
+	ct.DuplicateCombatant(index, count, opts)
+	return nil
+}
+
+func cmdInitiative(ct *CombatTracker, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: init <target> <value>")
+	}
+
+	index, err := ct.ResolveTarget(args[0])
+	if err != nil {
+		return err
+	}
+	initiative, err := ct.parseAmount(args[1])
+	if err != nil {
+		return err
+	}
+
+	ct.ChangeInitiative(index, initiative)
+	return nil
+}
+
+func cmdGroup(ct *CombatTracker, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: group <target>")
+	}
+
+	index, err := ct.ResolveTarget(args[0])
+	if err != nil {
+		return err
+	}
+	if ct.Combatants[index].Group == "" {
+		return fmt.Errorf("%s is not part of a group", ct.Combatants[index].Name)
+	}
+
+	ct.ToggleGroupCollapsed(index)
+	return nil
+}
+
+func cmdDetails(ct *CombatTracker, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf(`usage: details "<campaign>" "<encounter>"`)
+	}
+
+	ct.SetEncounterDetails(args[0], args[1])
+	return nil
+}
+
+func cmdSave(ct *CombatTracker, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("usage: save [file]")
+	}
+
+	filename := ""
+	if len(args) == 1 {
+		filename = args[0]
+	}
+	return ct.SaveAs(filename)
+}
+
+func cmdLoad(ct *CombatTracker, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: load <file>")
+	}
+
+	loadedCT, err := loadFromFile(args[0], ct.output())
+	if err != nil {
+		return err
+	}
+	ct.replaceWith(loadedCT)
+	ct.println("Combat state loaded successfully!")
+	return nil
+}
+
+func cmdShow(ct *CombatTracker, args []string) error {
+	ct.DisplayCombatState()
+	return nil
+}
+
+func cmdHelp(ct *CombatTracker, args []string) error {
+	ct.println(commandHelp)
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+)
+
+// Dice is a group of identical dice in a formula, a negative count subtracts the roll
+type Dice struct {
+	Count int
+	Sides int
+}
+
+// DiceFormula is a parsed dice expression such as "2d6+3"
+type DiceFormula struct {
+	Dice     []Dice
+	Modifier int
+}
+
+// Limits on dice expressions, so a typo like 2000000000d6 is refused instead of
+// rolling until memory runs out
+const (
+	maxDice     = 1000 // Dice in one expression, across all its terms
+	maxDieSides = 1000
+)
+
+// rollDie rolls a single die, replaced when a deterministic roller is needed
+var rollDie = func(sides int) int {
+	return rand.Intn(sides) + 1
+}
+
+// ParseDice parses expressions like "d20", "2d6+3", "1d8+1d6-1" or a plain number
+func ParseDice(expr string) (DiceFormula, error) {
+	var formula DiceFormula
+
+	s := strings.ToLower(strings.ReplaceAll(expr, " ", ""))
+	if s == "" {
+		return formula, fmt.Errorf("empty dice expression")
+	}
+
+	// Split into signed terms
+	terms := []string{}
+	start := 0
+	for i := 1; i < len(s); i++ {
+		if s[i] == '+' || s[i] == '-' {
+			terms = append(terms, s[start:i])
+			start = i
+		}
+	}
+	terms = append(terms, s[start:])
+
+	dice := 0
+	for _, term := range terms {
+		sign := 1
+		if strings.HasPrefix(term, "+") {
+			term = term[1:]
+		} else if strings.HasPrefix(term, "-") {
+			sign = -1
+			term = term[1:]
+		}
+
+		countStr, sidesStr, isDice := strings.Cut(term, "d")
+		if !isDice {
+			n, err := strconv.Atoi(term)
+			if err != nil {
+				return formula, fmt.Errorf("invalid dice expression %q", expr)
+			}
+			formula.Modifier += sign * n
+			continue
+		}
+
+		count := 1
+		if countStr != "" {
+			var err error
+			count, err = strconv.Atoi(countStr)
+			if err != nil || count < 1 {
+				return formula, fmt.Errorf("invalid dice count in %q", expr)
+			}
+		}
+		if dice += count; dice > maxDice {
+			return formula, fmt.Errorf("too many dice in %q, at most %d can be rolled at once", expr, maxDice)
+		}
+		sides, err := strconv.Atoi(sidesStr)
+		if err != nil || sides < 1 {
+			return formula, fmt.Errorf("invalid die size in %q", expr)
+		}
+		if sides > maxDieSides {
+			return formula, fmt.Errorf("die size in %q is over %d", expr, maxDieSides)
+		}
+		formula.Dice = append(formula.Dice, Dice{Count: sign * count, Sides: sides})
+	}
+
+	return formula, nil
+}
+
+// Roll rolls the formula and returns the total along with each individual die result
+func (f DiceFormula) Roll() (int, []int) {
+	total := f.Modifier
+	rolls := []int{}
+	for _, d := range f.Dice {
+		count, sign := d.Count, 1
+		if count < 0 {
+			count, sign = -count, -1
+		}
+		for i := 0; i < count; i++ {
+			r := rollDie(d.Sides)
+			rolls = append(rolls, r)
+			total += sign * r
+		}
+	}
+	return total, rolls
+}
+
+// String formats the formula back into dice notation
+func (f DiceFormula) String() string {
+	var sb strings.Builder
+	for i, d := range f.Dice {
+		count := d.Count
+		if count < 0 {
+			sb.WriteString("-")
+			count = -count
+		} else if i > 0 {
+			sb.WriteString("+")
+		}
+		fmt.Fprintf(&sb, "%dd%d", count, d.Sides)
+	}
+	if f.Modifier > 0 && len(f.Dice) > 0 {
+		fmt.Fprintf(&sb, "+%d", f.Modifier)
+	} else if f.Modifier != 0 || len(f.Dice) == 0 {
+		fmt.Fprintf(&sb, "%d", f.Modifier)
+	}
+	return sb.String()
+}
+
+// RollDice parses and rolls a dice expression in one step
+func RollDice(expr string) (int, error) {
+	formula, err := ParseDice(expr)
+	if err != nil {
+		return 0, err
+	}
+	total, _ := formula.Roll()
+	return total, nil
+}
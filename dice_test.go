@@ -0,0 +1,35 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseDice(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string // The formula, or the start of the error
+	}{
+		{"2d6+3", "2d6+3"},
+		{"d20", "1d20"},
+		{"1000d6", "1000d6"},
+		{"d1000", "1d1000"},
+		{"2000000000d6", "too many dice"},
+		{"600d6+600d8", "too many dice"},
+		{"2d1001", "die size"},
+		{"2d", "invalid die size"},
+		{"0d6", "invalid dice count"},
+	}
+	for _, tt := range tests {
+		f, err := ParseDice(tt.expr)
+		got := ""
+		if err != nil {
+			got = err.Error()
+		} else {
+			got = f.String()
+		}
+		if !strings.HasPrefix(got, tt.want) {
+			t.Errorf("ParseDice(%q) gave %q, want %q", tt.expr, got, tt.want)
+		}
+	}
+}
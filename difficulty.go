@@ -0,0 +1,288 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Encounter difficulties, from the Dungeon Master's Guide
+const (
+	DifficultyTrivial = "trivial"
+	DifficultyEasy    = "easy"
+	DifficultyMedium  = "medium"
+	DifficultyHard    = "hard"
+	DifficultyDeadly  = "deadly"
+)
+
+// crXP is the experience a monster of each challenge rating is worth
+var crXP = map[string]int{
+	"0": 10, "1/8": 25, "1/4": 50, "1/2": 100,
+	"1": 200, "2": 450, "3": 700, "4": 1100, "5": 1800,
+	"6": 2300, "7": 2900, "8": 3900, "9": 5000, "10": 5900,
+	"11": 7200, "12": 8400, "13": 10000, "14": 11500, "15": 13000,
+	"16": 15000, "17": 18000, "18": 20000, "19": 22000, "20": 25000,
+	"21": 33000, "22": 41000, "23": 50000, "24": 62000, "25": 75000,
+	"26": 90000, "27": 105000, "28": 120000, "29": 135000, "30": 155000,
+}
+
+// xpThresholds are each character level's easy, medium, hard and deadly thresholds
+var xpThresholds = [21][4]int{
+	{},
+	{25, 50, 75, 100},
+	{50, 100, 150, 200},
+	{75, 150, 225, 400},
+	{125, 250, 375, 500},
+	{250, 500, 750, 1100},
+	{300, 600, 900, 1400},
+	{350, 750, 1100, 1700},
+	{450, 900, 1400, 2100},
+	{550, 1100, 1600, 2400},
+	{600, 1200, 1900, 2800},
+	{800, 1600, 2400, 3600},
+	{1000, 2000, 3000, 4500},
+	{1100, 2200, 3400, 5100},
+	{1250, 2500, 3800, 5700},
+	{1400, 2800, 4300, 6400},
+	{1600, 3200, 4800, 7200},
+	{2000, 3900, 5900, 8800},
+	{2100, 4200, 6300, 9500},
+	{2400, 4900, 7300, 10900},
+	{2800, 5700, 8500, 12700},
+}
+
+// groupMultipliers scale monster XP for the number of monsters. The first and
+// last are only reached through the party size adjustment.
+var groupMultipliers = []float64{0.5, 1, 1.5, 2, 2.5, 3, 4, 5}
+
+// Difficulty is an encounter's difficulty for the party in the tracker
+type Difficulty struct {
+	Players     int    // Players with a level
+	UnsetLevels int    // Players without one, left out of the thresholds
+	Monsters    int    // Monsters with a challenge rating
+	Unrated     int    // Monsters without one, left out of the count
+	Thresholds  [4]int // Party's easy, medium, hard and deadly thresholds
+	XP          int    // Total monster XP
+	Multiplier  float64
+	AdjustedXP  int
+	Rating      string // One of the Difficulty constants
+}
+
+// normalizeCR reads a challenge rating such as "1/4", "0.25" or "5"
+func normalizeCR(cr string) (string, error) {
+	cr = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(cr)), "cr")
+	switch cr {
+	case "0.125", ".125":
+		cr = "1/8"
+	case "0.25", ".25":
+		cr = "1/4"
+	case "0.5", ".5":
+		cr = "1/2"
+	}
+	if _, ok := crXP[cr]; !ok {
+		return "", fmt.Errorf("invalid challenge rating %q", cr)
+	}
+	return cr, nil
+}
+
+// CRXP returns the XP for a challenge rating, zero when it isn't one
+func CRXP(cr string) int {
+	cr, err := normalizeCR(cr)
+	if err != nil {
+		return 0
+	}
+	return crXP[cr]
+}
+
+// groupMultiplier picks the XP multiplier for a number of monsters facing a
+// party of the given size. Small parties use the next multiplier up, large
+// parties the next one down.
+func groupMultiplier(monsters, players int) float64 {
+	step := 1
+	switch {
+	case monsters >= 15:
+		step = 6
+	case monsters >= 11:
+		step = 5
+	case monsters >= 7:
+		step = 4
+	case monsters >= 3:
+		step = 3
+	case monsters == 2:
+		step = 2
+	}
+
+	if players < 3 {
+		step++
+	} else if players >= 6 {
+		step--
+	}
+	return groupMultipliers[step]
+}
+
+// Difficulty rates the encounter against the players' XP thresholds. It
+// returns false until there is a player with a level and a monster with a
+// challenge rating to compare.
+func (ct *CombatTracker) Difficulty() (Difficulty, bool) {
+	var d Difficulty
+	for _, c := range ct.Combatants {
+		switch {
+		case c.IsPlayer && c.Level >= 1 && c.Level < len(xpThresholds):
+			d.Players++
+			for i, threshold := range xpThresholds[c.Level] {
+				d.Thresholds[i] += threshold
+			}
+		case c.IsPlayer:
+			d.UnsetLevels++
+		case c.XP > 0:
+			d.Monsters++
+			d.XP += c.XP
+		default:
+			d.Unrated++
+		}
+	}
+	if d.Players == 0 || d.Monsters == 0 {
+		return d, false
+	}
+
+	// Players without a level still count towards the size of the party
+	d.Multiplier = groupMultiplier(d.Monsters, d.Players+d.UnsetLevels)
+	d.AdjustedXP = int(float64(d.XP) * d.Multiplier)
+	d.Rating = DifficultyTrivial
+	for i, rating := range []string{DifficultyEasy, DifficultyMedium, DifficultyHard, DifficultyDeadly} {
+		if d.AdjustedXP >= d.Thresholds[i] {
+			d.Rating = rating
+		}
+	}
+	return d, true
+}
+
+// summary is the one-line verdict shown with the combat state
+func (d Difficulty) summary() string {
+	return fmt.Sprintf("Difficulty: %s (%s adjusted XP, deadly at %s)",
+		strings.ToUpper(d.Rating[:1])+d.Rating[1:], formatXP(d.AdjustedXP), formatXP(d.Thresholds[3]))
+}
+
+// ShowDifficulty prints how the encounter's difficulty was worked out
+func (ct *CombatTracker) ShowDifficulty() {
+	d, ok := ct.Difficulty()
+	if !ok {
+		ct.println("Set player levels with level <targets> <n> and monster challenge ratings with cr <targets> <cr> first.")
+		return
+	}
+
+	ct.println("\n===== ENCOUNTER DIFFICULTY =====")
+	ct.printf("Party: %d players, thresholds Easy %s, Medium %s, Hard %s, Deadly %s\n", d.Players,
+		formatXP(d.Thresholds[0]), formatXP(d.Thresholds[1]), formatXP(d.Thresholds[2]), formatXP(d.Thresholds[3]))
+	ct.printf("Monsters: %d worth %s XP, x%g for the group = %s adjusted XP\n", d.Monsters, formatXP(d.XP), d.Multiplier, formatXP(d.AdjustedXP))
+	if d.UnsetLevels > 0 {
+		ct.printf("%d player(s) without a level aren't counted.\n", d.UnsetLevels)
+	}
+	if d.Unrated > 0 {
+		ct.printf("%d monster(s) without a challenge rating aren't counted.\n", d.Unrated)
+	}
+	ct.println(d.summary())
+}
+
+// formatXP writes an XP total with thousands separators
+func formatXP(xp int) string {
+	s := strconv.Itoa(xp)
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	return s
+}
+
+// SetChallengeRating sets monsters' challenge rating and the XP it is worth
+func (ct *CombatTracker) SetChallengeRating(indices []int, cr string) {
+	cr, err := normalizeCR(cr)
+	if err != nil {
+		ct.println(err)
+		return
+	}
+	for _, index := range indices {
+		if index < 0 || index >= len(ct.Combatants) {
+			ct.println("Invalid combatant index!")
+			return
+		}
+	}
+
+	for _, index := range indices {
+		c := &ct.Combatants[index]
+		c.CR = cr
+		c.XP = crXP[cr]
+		ct.printf("%s is CR %s (%s XP)\n", c.Name, cr, formatXP(c.XP))
+	}
+
+	// Auto-save state
+	ct.AutoSave()
+}
+
+// SetLevel sets player characters' levels
+func (ct *CombatTracker) SetLevel(indices []int, level int) {
+	for _, index := range indices {
+		if index < 0 || index >= len(ct.Combatants) {
+			ct.println("Invalid combatant index!")
+			return
+		}
+	}
+
+	for _, index := range indices {
+		c := &ct.Combatants[index]
+		c.Level = level
+		ct.printf("%s is level %d\n", c.Name, level)
+	}
+
+	// Auto-save state
+	ct.AutoSave()
+}
+
+func cmdCR(ct *CombatTracker, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: cr <targets> <challenge rating>")
+	}
+
+	indices, err := ct.resolveTargetList(args[0])
+	if err != nil {
+		return err
+	}
+	if _, err := normalizeCR(args[1]); err != nil {
+		return err
+	}
+	for _, index := range indices {
+		if ct.Combatants[index].IsPlayer {
+			return fmt.Errorf("%s is a player, use level instead", ct.Combatants[index].Name)
+		}
+	}
+
+	ct.SetChallengeRating(indices, args[1])
+	return nil
+}
+
+func cmdLevel(ct *CombatTracker, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: level <targets> <level>")
+	}
+
+	indices, err := ct.resolveTargetList(args[0])
+	if err != nil {
+		return err
+	}
+	level, err := strconv.Atoi(args[1])
+	if err != nil || level < 1 || level >= len(xpThresholds) {
+		return fmt.Errorf("level must be 1 to %d", len(xpThresholds)-1)
+	}
+	for _, index := range indices {
+		if !ct.Combatants[index].IsPlayer {
+			return fmt.Errorf("%s is a monster, use cr instead", ct.Combatants[index].Name)
+		}
+	}
+
+	ct.SetLevel(indices, level)
+	return nil
+}
+
+func cmdDifficulty(ct *CombatTracker, args []string) error {
+	ct.ShowDifficulty()
+	return nil
+}
@@ -0,0 +1,473 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// encounterLibraryEnv names the environment variable that moves the encounter library
+const encounterLibraryEnv = "COMBAT_TRACKER_ENCOUNTERS"
+
+// encounterLibrary is the library directory given with -encounters, if any
+var encounterLibrary string
+
+// Encounter is a fight prepared ahead of time and kept in the encounter library
+type Encounter struct {
+	Name        string             `json:"name"`
+	Campaign    string             `json:"campaign,omitempty"`
+	Description string             `json:"description,omitempty"`
+	Monsters    []EncounterMonster `json:"monsters"`
+}
+
+// EncounterMonster is one kind of monster in a prepared encounter
+type EncounterMonster struct {
+	Name       string   `json:"name"`
+	Count      int      `json:"count,omitempty"`      // How many to add, one when left out
+	HP         Rollable `json:"hp"`                   // Max HP, rolled for each copy when it's dice
+	Initiative Rollable `json:"initiative,omitempty"` // Rolled at launch, d20 when left out
+	Group      string   `json:"group,omitempty"`      // Monsters in the same group share a turn
+	CR         string   `json:"cr,omitempty"`         // Challenge rating, which sets the XP
+	AC         int      `json:"ac,omitempty"`         // Armor class attacks are rolled against
+	Loot       []string `json:"loot,omitempty"`       // Loot notes each copy carries
+	Hidden     bool     `json:"hidden,omitempty"`
+	Alias      string   `json:"alias,omitempty"`
+}
+
+// Rollable is a number or a dice formula such as "2d6+3". Encounter files may
+// give it as a JSON number or a string.
+type Rollable string
+
+// UnmarshalJSON accepts either a JSON number or a string
+func (r *Rollable) UnmarshalJSON(data []byte) error {
+	var n int
+	if json.Unmarshal(data, &n) == nil {
+		*r = Rollable(strconv.Itoa(n))
+		return nil
+	}
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("expected a number or dice such as \"2d6+3\", got %s", data)
+	}
+	*r = Rollable(s)
+	return nil
+}
+
+// MarshalJSON writes plain numbers as JSON numbers, keeping files easy to edit by hand
+func (r Rollable) MarshalJSON() ([]byte, error) {
+	if n, err := strconv.Atoi(string(r)); err == nil {
+		return json.Marshal(n)
+	}
+	return json.Marshal(string(r))
+}
+
+// libraryDir is the directory holding the encounter library
+func libraryDir() string {
+	if encounterLibrary != "" {
+		return encounterLibrary
+	}
+	if dir := os.Getenv(encounterLibraryEnv); dir != "" {
+		return dir
+	}
+	return "encounters"
+}
+
+// encounterSlug turns an encounter name into its file name in the library
+func encounterSlug(name string) string {
+	var sb strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			if dash && sb.Len() > 0 {
+				sb.WriteByte('-')
+			}
+			sb.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return sb.String()
+}
+
+// ListEncounters returns every encounter in the library, sorted by name
+func ListEncounters() ([]Encounter, error) {
+	paths, err := filepath.Glob(filepath.Join(libraryDir(), "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	var encounters []Encounter
+	for _, path := range paths {
+		encounter, err := readEncounter(path)
+		if err != nil {
+			return nil, err
+		}
+		encounters = append(encounters, *encounter)
+	}
+	sort.Slice(encounters, func(i, j int) bool {
+		return strings.ToLower(encounters[i].Name) < strings.ToLower(encounters[j].Name)
+	})
+	return encounters, nil
+}
+
+// readEncounter reads one encounter file
+func readEncounter(path string) (*Encounter, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("error reading encounter: %v", err)
+	}
+
+	var encounter Encounter
+	if err := json.Unmarshal(data, &encounter); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", path, err)
+	}
+	if encounter.Name == "" {
+		encounter.Name = strings.TrimSuffix(filepath.Base(path), ".json")
+	}
+	return &encounter, nil
+}
+
+// FindEncounter looks an encounter up by name. An exact name wins, otherwise
+// the name must start exactly one encounter's name.
+func FindEncounter(name string) (*Encounter, error) {
+	encounters, err := ListEncounters()
+	if err != nil {
+		return nil, err
+	}
+
+	slug := encounterSlug(name)
+	matches := []int{}
+	for i, e := range encounters {
+		if encounterSlug(e.Name) == slug {
+			return &encounters[i], nil
+		}
+		if strings.HasPrefix(encounterSlug(e.Name), slug) {
+			matches = append(matches, i)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("no encounter named %q in %s", name, libraryDir())
+	case 1:
+		return &encounters[matches[0]], nil
+	default:
+		names := make([]string, len(matches))
+		for i, m := range matches {
+			names[i] = encounters[m].Name
+		}
+		return nil, fmt.Errorf("%q could be %s", name, strings.Join(names, ", "))
+	}
+}
+
+// SaveEncounter writes an encounter to the library, replacing one of the same name
+func SaveEncounter(encounter *Encounter) (string, error) {
+	slug := encounterSlug(encounter.Name)
+	if slug == "" {
+		return "", fmt.Errorf("encounter name %q has no letters or digits", encounter.Name)
+	}
+	if err := os.MkdirAll(libraryDir(), 0755); err != nil {
+		return "", fmt.Errorf("error creating encounter library: %v", err)
+	}
+
+	data, err := json.MarshalIndent(encounter, "", "    ")
+	if err != nil {
+		return "", fmt.Errorf("error creating JSON: %v", err)
+	}
+	path := filepath.Join(libraryDir(), slug+".json")
+	if err := writeFileAtomic(path, data, 0644); err != nil {
+		return "", fmt.Errorf("error writing encounter: %v", err)
+	}
+	return path, nil
+}
+
+// count is how many copies of the monster an encounter adds
+func (m EncounterMonster) count() int {
+	if m.Count < 1 {
+		return 1
+	}
+	return m.Count
+}
+
+// formulas parses the monster's HP and initiative
+func (m EncounterMonster) formulas() (hp, initiative DiceFormula, err error) {
+	hp, err = ParseDice(string(m.HP))
+	if err != nil {
+		return hp, initiative, fmt.Errorf("%s hp: %v", m.Name, err)
+	}
+	init := string(m.Initiative)
+	if init == "" {
+		init = "d20"
+	}
+	initiative, err = ParseDice(init)
+	if err != nil {
+		return hp, initiative, fmt.Errorf("%s initiative: %v", m.Name, err)
+	}
+	return hp, initiative, nil
+}
+
+// CreateEncounter stores the monsters in the tracker as an encounter in the
+// library. Initiative is left to be rolled when the encounter is launched.
+func (ct *CombatTracker) CreateEncounter(name, description string) error {
+	encounter := &Encounter{
+		Name:        name,
+		Campaign:    ct.CampaignName,
+		Description: description,
+		Monsters:    []EncounterMonster{},
+	}
+	for _, c := range ct.Combatants {
+		if c.IsPlayer {
+			continue
+		}
+		encounter.Monsters = append(encounter.Monsters, EncounterMonster{
+			Name:   c.Name,
+			HP:     Rollable(strconv.Itoa(c.MaxHP)),
+			Group:  c.Group,
+			CR:     c.CR,
+			AC:     c.AC,
+			Loot:   c.Loot,
+			Hidden: c.Hidden,
+			Alias:  c.Alias,
+		})
+	}
+	if len(encounter.Monsters) == 0 {
+		return fmt.Errorf("no monsters to save, add them first")
+	}
+
+	path, err := SaveEncounter(encounter)
+	if err != nil {
+		return err
+	}
+	ct.printf("Saved %s with %d monsters to %s.\n", name, len(encounter.Monsters), path)
+	return nil
+}
+
+// ShowEncounters lists the encounters in the library
+func (ct *CombatTracker) ShowEncounters() error {
+	encounters, err := ListEncounters()
+	if err != nil {
+		return err
+	}
+	if len(encounters) == 0 {
+		ct.printf("No encounters in %s yet, use encounter create <name> to add one.\n", libraryDir())
+		return nil
+	}
+
+	ct.printf("Encounters in %s:\n", libraryDir())
+	for _, e := range encounters {
+		monsters := 0
+		for _, m := range e.Monsters {
+			monsters += m.count()
+		}
+		campaign := ""
+		if e.Campaign != "" {
+			campaign = fmt.Sprintf(" [%s]", e.Campaign)
+		}
+		ct.printf("  %-30s %3d monsters%s\n", e.Name, monsters, campaign)
+		if e.Description != "" {
+			ct.printf("      %s\n", e.Description)
+		}
+	}
+	return nil
+}
+
+// PreviewEncounter shows what launching an encounter would add
+func (ct *CombatTracker) PreviewEncounter(name string) error {
+	encounter, err := FindEncounter(name)
+	if err != nil {
+		return err
+	}
+
+	ct.printf("\n===== %s =====\n", encounter.Name)
+	if encounter.Campaign != "" {
+		ct.printf("Campaign: %s\n", encounter.Campaign)
+	}
+	if encounter.Description != "" {
+		ct.println(encounter.Description)
+	}
+	ct.println("-------------------")
+	total := 0
+	for _, m := range encounter.Monsters {
+		hp, initiative, err := m.formulas()
+		if err != nil {
+			return err
+		}
+		extras := []string{}
+		if m.CR != "" {
+			extras = append(extras, "CR "+m.CR)
+		}
+		if m.AC > 0 {
+			extras = append(extras, fmt.Sprintf("AC %d", m.AC))
+		}
+		if m.Group != "" {
+			extras = append(extras, "group "+m.Group)
+		}
+		if m.Hidden {
+			extras = append(extras, "hidden")
+		}
+		if m.Alias != "" {
+			extras = append(extras, "shown as "+m.Alias)
+		}
+		if len(m.Loot) > 0 {
+			extras = append(extras, "carries "+strings.Join(m.Loot, "; "))
+		}
+		extra := ""
+		if len(extras) > 0 {
+			extra = " (" + strings.Join(extras, ", ") + ")"
+		}
+		ct.printf("%dx %s - HP: %s, Init: %s%s\n", m.count(), m.Name, hp, initiative, extra)
+		total += m.count()
+	}
+	ct.println("-------------------")
+	ct.printf("%d monsters\n", total)
+	return nil
+}
+
+// LaunchEncounter sets up a prepared encounter against the party. Monsters left
+// from the last fight are removed, players stay or come in from the party file,
+// and HP and initiative are rolled for every new monster.
+func (ct *CombatTracker) LaunchEncounter(name string) error {
+	if ct.IsActive {
+		return fmt.Errorf("end the current combat before launching another encounter")
+	}
+	encounter, err := FindEncounter(name)
+	if err != nil {
+		return err
+	}
+
+	// Check every formula before touching the tracker
+	for _, m := range encounter.Monsters {
+		if _, _, err := m.formulas(); err != nil {
+			return err
+		}
+	}
+
+	party := []Combatant{}
+	for _, c := range ct.Combatants {
+		if c.IsPlayer {
+			party = append(party, c)
+		}
+	}
+	if removed := len(ct.Combatants) - len(party); removed > 0 {
+		ct.printf("Removed %d monster(s) left from the last encounter.\n", removed)
+	}
+	ct.Combatants = party
+	ct.CollapsedGroups = nil
+
+	// The party comes in as the party file last left it
+	ct.PullParty(true)
+
+	if encounter.Campaign != "" {
+		ct.CampaignName = encounter.Campaign
+	}
+	ct.EncounterName = encounter.Name
+	ct.Round = 0
+	ct.CurrentTurnIdx = 0
+	ct.record(fmt.Sprintf("Encounter %s is set up", encounter.Name))
+
+	// Group members share the initiative rolled for the first of them
+	groupInitiative := make(map[string]int)
+	for _, m := range encounter.Monsters {
+		hpFormula, initFormula, _ := m.formulas()
+		for _, monsterName := range ct.launchNames(m.Name, m.count()) {
+			maxHP, _ := hpFormula.Roll()
+			if maxHP < 1 {
+				maxHP = 1
+			}
+
+			initiative, rolled := groupInitiative[m.Group]
+			if !rolled {
+				initiative, _ = initFormula.Roll()
+				if m.Group != "" {
+					groupInitiative[m.Group] = initiative
+				}
+			}
+
+			ct.Combatants = append(ct.Combatants, Combatant{
+				Name:          monsterName,
+				Initiative:    initiative,
+				MaxHP:         maxHP,
+				CurrentHP:     maxHP,
+				IsConscious:   true,
+				StatusEffects: []string{},
+				Group:         m.Group,
+				CR:            m.CR,
+				XP:            CRXP(m.CR),
+				AC:            m.AC,
+				Loot:          append([]string(nil), m.Loot...),
+				Hidden:        m.Hidden,
+				Alias:         m.Alias,
+			})
+			ct.logStatsf(false, "%s joins the encounter (Init: %d, HP: %d)", monsterName, initiative, maxHP)
+		}
+	}
+
+	ct.SortByInitiative()
+	ct.printf("Launched %s. Set the players' initiative with init <target> <value>, then type start.\n", encounter.Name)
+
+	// Auto-save state
+	ct.AutoSave()
+	return nil
+}
+
+// launchNames picks count unused names for monsters called name. A single
+// monster keeps the name, several are numbered from 1.
+func (ct *CombatTracker) launchNames(name string, count int) []string {
+	taken := make(map[string]bool, len(ct.Combatants))
+	for _, c := range ct.Combatants {
+		taken[c.Name] = true
+	}
+	if count == 1 && !taken[name] {
+		return []string{name}
+	}
+
+	names := make([]string, 0, count)
+	for n := 1; len(names) < count; n++ {
+		candidate := fmt.Sprintf("%s%d", name, n)
+		if !taken[candidate] {
+			names = append(names, candidate)
+		}
+	}
+	return names
+}
+
+func cmdEncounter(ct *CombatTracker, args []string) error {
+	usage := fmt.Errorf(`usage: encounter list | preview <name> | launch <name> | create "<name>" ["<description>"]`)
+	if len(args) == 0 {
+		return usage
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "list", "ls":
+		if len(args) != 1 {
+			return usage
+		}
+		return ct.ShowEncounters()
+	case "preview", "show":
+		if len(args) < 2 {
+			return usage
+		}
+		return ct.PreviewEncounter(strings.Join(args[1:], " "))
+	case "launch":
+		if len(args) < 2 {
+			return usage
+		}
+		return ct.LaunchEncounter(strings.Join(args[1:], " "))
+	case "create":
+		if len(args) < 2 || len(args) > 3 {
+			return usage
+		}
+		description := ""
+		if len(args) == 3 {
+			description = args[2]
+		}
+		return ct.CreateEncounter(args[1], description)
+	default:
+		return usage
+	}
+}
@@ -1,3 +1,3 @@
-module main
+module combat-tracker
 
 go 1.22.2
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// testClock is a clock for clockNow that only moves when told to
+type testClock struct {
+	now time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	return c.now
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.now = c.now.Add(d)
+}
+
+// useTestClock replaces clockNow with a test clock for the rest of the test
+func useTestClock(t *testing.T) *testClock {
+	t.Helper()
+	clock := &testClock{now: time.Date(2026, 1, 1, 19, 0, 0, 0, time.UTC)}
+	previous := clockNow
+	clockNow = clock.Now
+	t.Cleanup(func() { clockNow = previous })
+	return clock
+}
+
+// useTestDice replaces rollDie with a seeded roller for the rest of the test
+func useTestDice(t *testing.T, seed int64) {
+	t.Helper()
+	rng := rand.New(rand.NewSource(seed))
+	previous := rollDie
+	rollDie = func(sides int) int {
+		return rng.Intn(sides) + 1
+	}
+	t.Cleanup(func() { rollDie = previous })
+}
+
+// newTestTracker returns an empty tracker writing to a buffer, with seeded dice,
+// a test clock, and the party file and encounter library in a temporary directory
+func newTestTracker(t *testing.T) (*CombatTracker, *bytes.Buffer) {
+	t.Helper()
+	useTestDice(t, 1)
+	useTestClock(t)
+
+	dir := t.TempDir()
+	previousParty, previousLibrary := partyFile, encounterLibrary
+	partyFile = filepath.Join(dir, "party.json")
+	encounterLibrary = filepath.Join(dir, "encounters")
+	t.Cleanup(func() {
+		partyFile, encounterLibrary = previousParty, previousLibrary
+	})
+
+	var out bytes.Buffer
+	ct := NewCombatTracker()
+	ct.SetOutput(&out)
+	return ct, &out
+}
+
+// checkGolden compares got with the golden file at path, or rewrites the file
+// when the tests run with -update
+func checkGolden(t *testing.T, path, got string) {
+	t.Helper()
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v, run go test -update to create it", err)
+	}
+	if got != string(want) {
+		diff := diffLines(strings.Split(string(want), "\n"), strings.Split(got, "\n"))
+		t.Errorf("%s differs from the golden file:\n%s", path, strings.Join(diff, "\n"))
+	}
+}
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// DisplayName is the name players see: the alias when there is one
+func (c *Combatant) DisplayName() string {
+	if c.Alias != "" {
+		return c.Alias
+	}
+	return c.Name
+}
+
+// SetHidden hides combatants from players or reveals them. Revealed combatants
+// take their place in the visible initiative order straight away.
+func (ct *CombatTracker) SetHidden(indices []int, hidden bool) {
+	for _, index := range indices {
+		if index < 0 || index >= len(ct.Combatants) {
+			ct.println("Invalid combatant index!")
+			return
+		}
+	}
+
+	for _, index := range indices {
+		c := &ct.Combatants[index]
+		if c.Hidden == hidden {
+			continue
+		}
+		c.Hidden = hidden
+		if hidden {
+			ct.printf("%s is now hidden from players.\n", c.Name)
+		} else {
+			ct.logf("%s appears!", c.DisplayName())
+		}
+	}
+
+	// Auto-save state
+	ct.AutoSave()
+}
+
+// SetAlias sets the name players see for a combatant, an empty alias shows the real name
+func (ct *CombatTracker) SetAlias(index int, alias string) {
+	if index < 0 || index >= len(ct.Combatants) {
+		ct.println("Invalid combatant index!")
+		return
+	}
+
+	c := &ct.Combatants[index]
+	c.Alias = strings.TrimSpace(alias)
+	if c.Alias == "" {
+		ct.printf("Players now see %s by name.\n", c.Name)
+	} else {
+		ct.printf("Players now see %s as %s.\n", c.Name, c.Alias)
+	}
+
+	// Auto-save state
+	ct.AutoSave()
+}
+
+// secretGroup reports whether players mustn't see a group's name because one of
+// its members is hidden or goes by an alias. The group is usually named after
+// the real monster, which would give the secret away.
+func (ct *CombatTracker) secretGroup(group string) bool {
+	if group == "" {
+		return false
+	}
+	for i := range ct.Combatants {
+		c := &ct.Combatants[i]
+		if c.Group == group && (c.Hidden || c.Alias != "") {
+			return true
+		}
+	}
+	return false
+}
+
+// playerGroupName is the group of the combatant at index as players see it,
+// empty when the group's name is secret
+func (ct *CombatTracker) playerGroupName(index int) string {
+	group := ct.Combatants[index].Group
+	if ct.secretGroup(group) {
+		return ""
+	}
+	return group
+}
+
+// playerTurnName is turnName as players see it: empty while a hidden combatant
+// has the turn, and with aliases in place of real names. A group whose name is
+// secret is named by its visible members instead.
+func (ct *CombatTracker) playerTurnName() string {
+	if !ct.IsActive || ct.CurrentTurnIdx < 0 || ct.CurrentTurnIdx >= len(ct.Combatants) {
+		return ""
+	}
+
+	members := ct.groupMembers(ct.CurrentTurnIdx)
+	if len(members) == 1 {
+		c := &ct.Combatants[ct.CurrentTurnIdx]
+		if c.Hidden {
+			return ""
+		}
+		return c.DisplayName()
+	}
+
+	names := []string{}
+	for _, m := range members {
+		if !ct.Combatants[m].Hidden {
+			names = append(names, ct.Combatants[m].DisplayName())
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	if group := ct.playerGroupName(ct.CurrentTurnIdx); group != "" {
+		return group
+	}
+	return strings.Join(names, ", ")
+}
+
+// playerRedactor returns a function that rewrites log messages for players.
+// Messages naming a hidden combatant are dropped, and aliased combatants are
+// named by their alias. Secret group names are taken out of turn announcements,
+// and messages still naming one are dropped.
+func (ct *CombatTracker) playerRedactor() func(message string) (string, bool) {
+	type secret struct {
+		name   *regexp.Regexp
+		alias  string
+		hidden bool
+	}
+
+	// Secret group names are dropped from "<group> group (<members>)", the way
+	// turnName describes a group, leaving the members to be redacted below
+	type groupSecret struct {
+		turn *regexp.Regexp
+		name *regexp.Regexp // Set unless the group is named after a combatant
+	}
+
+	names := map[string]bool{}
+	for i := range ct.Combatants {
+		names[ct.Combatants[i].Name] = true
+	}
+	var groups []groupSecret
+	seen := map[string]bool{}
+	for i := range ct.Combatants {
+		group := ct.Combatants[i].Group
+		if seen[group] || !ct.secretGroup(group) {
+			continue
+		}
+		seen[group] = true
+		g := groupSecret{turn: regexp.MustCompile(`(^|\W)` + regexp.QuoteMeta(group) + ` group \(([^)]*)\)`)}
+		if !names[group] {
+			g.name = regexp.MustCompile(`(^|\W)` + regexp.QuoteMeta(group) + `($|\W)`)
+		}
+		groups = append(groups, g)
+	}
+
+	var secrets []secret
+	for i := range ct.Combatants {
+		c := &ct.Combatants[i]
+		if !c.Hidden && c.Alias == "" {
+			continue
+		}
+		// Match whole names only, so hiding Goblin leaves Goblin2 alone
+		secrets = append(secrets, secret{
+			name:   regexp.MustCompile(`(^|\W)` + regexp.QuoteMeta(c.Name) + `($|\W)`),
+			alias:  strings.ReplaceAll(c.Alias, "$", "$$"),
+			hidden: c.Hidden,
+		})
+	}
+
+	return func(message string) (string, bool) {
+		for _, g := range groups {
+			message = g.turn.ReplaceAllString(message, "${1}${2}")
+			if g.name != nil && g.name.MatchString(message) {
+				return "", false
+			}
+		}
+		for _, s := range secrets {
+			if !s.name.MatchString(message) {
+				continue
+			}
+			if s.hidden {
+				return "", false
+			}
+			message = s.name.ReplaceAllString(message, "${1}"+s.alias+"${2}")
+		}
+		return message, true
+	}
+}
+
+func cmdHide(ct *CombatTracker, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: hide <targets>")
+	}
+
+	indices, err := ct.resolveTargetList(args[0])
+	if err != nil {
+		return err
+	}
+	ct.SetHidden(indices, true)
+	return nil
+}
+
+func cmdReveal(ct *CombatTracker, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: reveal <targets>")
+	}
+
+	indices, err := ct.resolveTargetList(args[0])
+	if err != nil {
+		return err
+	}
+	ct.SetHidden(indices, false)
+	return nil
+}
+
+func cmdAlias(ct *CombatTracker, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf(`usage: alias <target> "<name>" | alias <target> off`)
+	}
+
+	index, err := ct.ResolveTarget(args[0])
+	if err != nil {
+		return err
+	}
+
+	alias := strings.Join(args[1:], " ")
+	if strings.ToLower(alias) == "off" {
+		alias = ""
+	}
+	ct.SetAlias(index, alias)
+	return nil
+}
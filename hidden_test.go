@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPlayerViewKeepsGroupSecret(t *testing.T) {
+	ct, _ := newTestTracker(t)
+	ct.AddCombatant("Thorin", 10, 30, true)
+	ct.AddCombatant("Bride", 15, 40, false)
+	ct.AddCombatant("Bride2", 15, 40, false)
+	for i := range ct.Combatants {
+		if strings.HasPrefix(ct.Combatants[i].Name, "Bride") {
+			ct.Combatants[i].Group = "Strahd"
+		}
+	}
+	ct.StartCombat()
+
+	view := ct.PlayerView(false)
+	if view.CurrentTurn != "Strahd" || view.Combatants[0].Group != "Strahd" {
+		t.Fatalf("without secrets the group is named: got turn %q, group %q", view.CurrentTurn, view.Combatants[0].Group)
+	}
+
+	bride, err := ct.ResolveTarget("Bride")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct.SetAlias(bride, "Pale Woman")
+	view = ct.PlayerView(false)
+	if view.CurrentTurn != "Pale Woman, Bride2" {
+		t.Errorf("turn is %q, want the members' names", view.CurrentTurn)
+	}
+	for _, cv := range view.Combatants {
+		if cv.Group != "" {
+			t.Errorf("%s shows group %q", cv.Name, cv.Group)
+		}
+	}
+	for _, entry := range ct.playerLog(false) {
+		if strings.Contains(entry.Message, "Strahd") {
+			t.Errorf("player log names the group: %q", entry.Message)
+		}
+	}
+}
+
+func TestExportKeepsGroupSecret(t *testing.T) {
+	ct, _ := newTestTracker(t)
+	ct.AddCombatant("Bride", 15, 40, false)
+	ct.AddCombatant("Bride2", 15, 40, false)
+	ct.Combatants[0].Group, ct.Combatants[1].Group = "Strahd", "Strahd"
+	ct.SetAlias(0, "Pale Woman")
+
+	for _, format := range []string{"json", "csv", "md"} {
+		var out bytes.Buffer
+		if err := runExportSubcommand(ct, nil, &subcommandOptions{format: format, stdout: &out}); err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(out.String(), "Strahd") {
+			t.Errorf("%s export names the group:\n%s", format, out.String())
+		}
+	}
+}
+
+func TestStatsExportHidesSecrets(t *testing.T) {
+	ct, _ := newTestTracker(t)
+	ct.AddCombatant("Fighter", 15, 40, true)
+	ct.AddCombatant("Strahd", 20, 90, false)
+	ct.AddCombatant("Vampire Spawn", 10, 60, false)
+	ct.SetAlias(ct.indexByName("Strahd"), "Pale Stranger")
+	ct.SetHidden([]int{ct.indexByName("Vampire Spawn")}, true)
+	ct.StartCombat()
+
+	for _, format := range []string{"md", "csv"} {
+		var out bytes.Buffer
+		if err := ct.exportStats(format, "", &out, false); err != nil {
+			t.Fatal(err)
+		}
+		for _, secret := range []string{"Strahd", "Vampire Spawn"} {
+			if strings.Contains(out.String(), secret) {
+				t.Errorf("%s stats export names %s:\n%s", format, secret, out.String())
+			}
+		}
+		if !strings.Contains(out.String(), "Pale Stranger") {
+			t.Errorf("%s stats export is missing the alias:\n%s", format, out.String())
+		}
+
+		out.Reset()
+		if err := ct.exportStats(format, "", &out, true); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(out.String(), "Strahd") || !strings.Contains(out.String(), "Vampire Spawn") {
+			t.Errorf("GM %s stats export is missing combatants:\n%s", format, out.String())
+		}
+	}
+}
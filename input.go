@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+)
+
+// Input is the one reader of the program's standard input. The line editor, the
+// full-screen interface and the numbered menu prompts all read through it, so
+// input typed ahead or pasted waits for whichever of them asks next instead of
+// being stranded in another's buffer.
+type Input struct {
+	in      io.Reader
+	fd      uintptr
+	pending []byte // Read from in but not used yet
+	text    string // The line Scan read last
+}
+
+// stdin is the shared reader of standard input
+var stdin = NewInput(os.Stdin)
+
+// NewInput creates a reader of file
+func NewInput(file *os.File) *Input {
+	return &Input{in: file, fd: file.Fd()}
+}
+
+// Fd returns the file descriptor being read, for switching the terminal mode
+func (in *Input) Fd() uintptr {
+	return in.fd
+}
+
+// Read returns input typed ahead first, then reads more
+func (in *Input) Read(p []byte) (int, error) {
+	if len(in.pending) > 0 {
+		n := copy(p, in.pending)
+		in.pending = in.pending[n:]
+		return n, nil
+	}
+	return in.in.Read(p)
+}
+
+// Unread puts bytes back to be read before anything else
+func (in *Input) Unread(data []byte) {
+	in.pending = append(append([]byte{}, data...), in.pending...)
+}
+
+// Scan reads the next line, like bufio.Scanner. Lines may end in "\n", "\r\n" or
+// the "\r" a raw-mode terminal sends for Enter. Whatever follows the line stays
+// pending for the next reader.
+func (in *Input) Scan() bool {
+	for {
+		if i := bytes.IndexAny(in.pending, "\r\n"); i >= 0 {
+			in.text = string(in.pending[:i])
+			end := i + 1
+			if in.pending[i] == '\r' && end < len(in.pending) && in.pending[end] == '\n' {
+				end++
+			}
+			in.pending = in.pending[end:]
+			return true
+		}
+
+		buf := make([]byte, 256)
+		n, err := in.in.Read(buf)
+		in.pending = append(in.pending, buf[:n]...)
+		if err != nil {
+			// The last line may have no line ending
+			if len(in.pending) == 0 {
+				in.text = ""
+				return false
+			}
+			in.text = string(in.pending)
+			in.pending = nil
+			return true
+		}
+	}
+}
+
+// Text returns the line read by the last Scan
+func (in *Input) Text() string {
+	return in.text
+}
+
+// lineScanner reads input a line at a time, like bufio.Scanner
+type lineScanner interface {
+	Scan() bool
+	Text() string
+}
+
+// sessionInput reads the answers to menu prompts, letting go of the session
+// while it waits so the web server and player updates aren't held up
+type sessionInput struct {
+	*Input
+	session *Session
+}
+
+// Scan reads the next line with the session released
+func (in sessionInput) Scan() bool {
+	in.session.Unlock()
+	defer in.session.Lock()
+	return in.Input.Scan()
+}
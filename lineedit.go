@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxHistory is the number of command lines kept in the history file
+const maxHistory = 500
+
+// errInterrupted is returned by ReadLine when Ctrl-C is pressed
+var errInterrupted = errors.New("interrupted")
+
+// Completer returns the candidates for the word ending at pos in line,
+// along with the position where that word starts
+type Completer func(line []rune, pos int) (start int, candidates []string)
+
+// LineEditor reads lines from a raw-mode terminal with cursor movement,
+// history and tab completion
+type LineEditor struct {
+	in          *Input
+	out         io.Writer
+	history     []string
+	historyPath string
+	completer   Completer
+}
+
+// NewLineEditor creates an editor on stdin that loads and appends to historyPath
+func NewLineEditor(historyPath string, completer Completer) *LineEditor {
+	le := &LineEditor{
+		in:          stdin,
+		out:         os.Stdout,
+		historyPath: historyPath,
+		completer:   completer,
+	}
+	le.loadHistory()
+	return le
+}
+
+// defaultHistoryPath returns the history file in the user's home directory
+func defaultHistoryPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".combat_tracker_history")
+}
+
+// loadHistory reads previous sessions' commands, ignoring a missing file
+func (le *LineEditor) loadHistory() {
+	if le.historyPath == "" {
+		return
+	}
+
+	file, err := os.Open(le.historyPath)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			le.history = append(le.history, line)
+		}
+	}
+	if len(le.history) > maxHistory {
+		le.history = le.history[len(le.history)-maxHistory:]
+	}
+}
+
+// addHistory records a line and rewrites the history file when it grows too long
+func (le *LineEditor) addHistory(line string) {
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	if len(le.history) > 0 && le.history[len(le.history)-1] == line {
+		return
+	}
+	le.history = append(le.history, line)
+
+	if le.historyPath == "" {
+		return
+	}
+
+	if len(le.history) > maxHistory*2 {
+		le.history = le.history[len(le.history)-maxHistory:]
+		data := strings.Join(le.history, "\n") + "\n"
+		if err := os.WriteFile(le.historyPath, []byte(data), 0600); err != nil {
+			fmt.Fprintf(le.out, "Could not save history: %v\r\n", err)
+		}
+		return
+	}
+
+	file, err := os.OpenFile(le.historyPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		fmt.Fprintf(le.out, "Could not save history: %v\r\n", err)
+		return
+	}
+	defer file.Close()
+	fmt.Fprintln(file, line)
+}
+
+// readKey returns the next key press, which is one rune or a whole escape
+// sequence. Anything read past it goes back to the input for the next reader.
+func (le *LineEditor) readKey() (string, error) {
+	var data []byte
+	buf := make([]byte, 64)
+	for {
+		n, err := le.in.Read(buf)
+		data = append(data, buf[:n]...)
+		if len(data) > 0 {
+			if key, size := splitKey(data); size > 0 {
+				le.in.Unread(data[size:])
+				return key, nil
+			}
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// splitKey finds the first complete key in data, returning 0 if more bytes are needed
+func splitKey(data []byte) (string, int) {
+	if data[0] != 0x1b {
+		if !utf8.FullRune(data) {
+			return "", 0
+		}
+		_, size := utf8.DecodeRune(data)
+		return string(data[:size]), size
+	}
+
+	// A lone escape, or one followed by something that isn't a sequence
+	if len(data) == 1 {
+		return "\x1b", 1
+	}
+	if data[1] != '[' && data[1] != 'O' {
+		return "\x1b", 1
+	}
+
+	// CSI and SS3 sequences end with a byte in the range @ to ~
+	for i := 2; i < len(data); i++ {
+		if data[i] >= 0x40 && data[i] <= 0x7e {
+			return string(data[:i+1]), i + 1
+		}
+	}
+	return "", 0
+}
+
+// ReadLine shows prompt and edits a line until Enter. It returns io.EOF on Ctrl-D
+// with an empty line and errInterrupted on Ctrl-C.
+func (le *LineEditor) ReadLine(prompt string) (string, error) {
+	state, err := makeRaw(le.in.Fd())
+	if err != nil {
+		return "", err
+	}
+	defer restoreTerminal(le.in.Fd(), state)
+
+	// Only the last line of a multi-line prompt is redrawn while editing
+	fmt.Fprint(le.out, prompt)
+	if i := strings.LastIndex(prompt, "\n"); i >= 0 {
+		prompt = prompt[i+1:]
+	}
+
+	line := []rune{}
+	pos := 0
+	historyIdx := len(le.history)
+	draft := ""
+
+	redraw := func() {
+		fmt.Fprintf(le.out, "\r%s%s\x1b[K", prompt, string(line))
+		if back := len(line) - pos; back > 0 {
+			fmt.Fprintf(le.out, "\x1b[%dD", back)
+		}
+	}
+
+	setLine := func(s string) {
+		line = []rune(s)
+		pos = len(line)
+		redraw()
+	}
+
+	for {
+		key, err := le.readKey()
+		if err != nil {
+			return "", err
+		}
+
+		switch key {
+		case "\r", "\n": // Enter
+			fmt.Fprint(le.out, "\r\n")
+			result := string(line)
+			le.addHistory(strings.TrimSpace(result))
+			return result, nil
+
+		case "\x03": // Ctrl-C
+			fmt.Fprint(le.out, "^C\r\n")
+			return "", errInterrupted
+
+		case "\x04": // Ctrl-D
+			if len(line) == 0 {
+				fmt.Fprint(le.out, "\r\n")
+				return "", io.EOF
+			}
+			if pos < len(line) {
+				line = append(line[:pos], line[pos+1:]...)
+				redraw()
+			}
+
+		case "\x7f", "\x08": // Backspace
+			if pos > 0 {
+				line = append(line[:pos-1], line[pos:]...)
+				pos--
+				redraw()
+			}
+
+		case "\x1b[3~": // Delete
+			if pos < len(line) {
+				line = append(line[:pos], line[pos+1:]...)
+				redraw()
+			}
+
+		case "\x1b[D", "\x1bOD", "\x02": // Left, Ctrl-B
+			if pos > 0 {
+				pos--
+				redraw()
+			}
+
+		case "\x1b[C", "\x1bOC", "\x06": // Right, Ctrl-F
+			if pos < len(line) {
+				pos++
+				redraw()
+			}
+
+		case "\x1b[H", "\x1bOH", "\x1b[1~", "\x01": // Home, Ctrl-A
+			pos = 0
+			redraw()
+
+		case "\x1b[F", "\x1bOF", "\x1b[4~", "\x05": // End, Ctrl-E
+			pos = len(line)
+			redraw()
+
+		case "\x1b[A", "\x1bOA", "\x10": // Up, Ctrl-P
+			if historyIdx > 0 {
+				if historyIdx == len(le.history) {
+					draft = string(line)
+				}
+				historyIdx--
+				setLine(le.history[historyIdx])
+			}
+
+		case "\x1b[B", "\x1bOB", "\x0e": // Down, Ctrl-N
+			if historyIdx < len(le.history) {
+				historyIdx++
+				if historyIdx == len(le.history) {
+					setLine(draft)
+				} else {
+					setLine(le.history[historyIdx])
+				}
+			}
+
+		case "\x15": // Ctrl-U deletes to the start of the line
+			line = line[pos:]
+			pos = 0
+			redraw()
+
+		case "\x0b": // Ctrl-K deletes to the end of the line
+			line = line[:pos]
+			redraw()
+
+		case "\x17": // Ctrl-W deletes the previous word
+			start := pos
+			for start > 0 && line[start-1] == ' ' {
+				start--
+			}
+			for start > 0 && line[start-1] != ' ' {
+				start--
+			}
+			line = append(line[:start], line[pos:]...)
+			pos = start
+			redraw()
+
+		case "\x0c": // Ctrl-L clears the screen
+			fmt.Fprint(le.out, "\x1b[H\x1b[2J")
+			redraw()
+
+		case "\t":
+			var options []string
+			line, pos, options = le.complete(line, pos)
+			if len(options) > 0 {
+				fmt.Fprintf(le.out, "\r\n%s\r\n", strings.Join(options, "  "))
+			}
+			redraw()
+
+		default:
+			r, _ := utf8.DecodeRuneInString(key)
+			if len(key) > utf8.RuneLen(r) || r < 0x20 {
+				continue // Unhandled control key or escape sequence
+			}
+			line = append(line[:pos], append([]rune{r}, line[pos:]...)...)
+			pos++
+			redraw()
+		}
+	}
+}
+
+// complete fills in the word under the cursor. When there are several candidates
+// and nothing more can be filled in, the candidates are returned for display.
+func (le *LineEditor) complete(line []rune, pos int) ([]rune, int, []string) {
+	if le.completer == nil {
+		return line, pos, nil
+	}
+
+	start, candidates := le.completer(line, pos)
+	if len(candidates) == 0 {
+		return line, pos, nil
+	}
+
+	word := string(line[start:pos])
+	replacement := candidates[0]
+	if len(candidates) == 1 {
+		replacement += " "
+	} else {
+		replacement = commonPrefix(candidates)
+		if len([]rune(replacement)) <= len([]rune(word)) {
+			// Nothing more to fill in, so show what's possible
+			return line, pos, candidates
+		}
+	}
+
+	newLine := append([]rune{}, line[:start]...)
+	newLine = append(newLine, []rune(replacement)...)
+	newPos := len(newLine)
+	newLine = append(newLine, line[pos:]...)
+	return newLine, newPos, nil
+}
+
+// commonPrefix returns the longest case-insensitive prefix shared by all words,
+// spelled as in the first word
+func commonPrefix(words []string) string {
+	prefix := []rune(words[0])
+	for _, w := range words[1:] {
+		wr := []rune(w)
+		n := 0
+		for n < len(prefix) && n < len(wr) && strings.EqualFold(string(prefix[n]), string(wr[n])) {
+			n++
+		}
+		prefix = prefix[:n]
+	}
+	return string(prefix)
+}
+
+// trackerCompleter completes command words, combatant names and status effects
+// for the command language. It reads the tracker through get so it always sees
+// the currently loaded state.
+func trackerCompleter(get func() *CombatTracker) Completer {
+	return func(line []rune, pos int) (int, []string) {
+		start := pos
+		for start > 0 && line[start-1] != ' ' {
+			start--
+		}
+		word := strings.ToLower(string(line[start:pos]))
+		before := strings.Fields(string(line[:start]))
+
+		matches := func(options []string, prefix string) []string {
+			found := []string{}
+			for _, option := range options {
+				if strings.HasPrefix(strings.ToLower(strings.TrimPrefix(option, `"`)), strings.ToLower(prefix)) {
+					found = append(found, option)
+				}
+			}
+			return found
+		}
+
+		// The first word is always a command
+		if len(before) == 0 {
+			return start, matches(commandWords(), word)
+		}
+
+		ct := get()
+
+		// Conditions are written as +Effect or -Effect
+		if strings.HasPrefix(word, "+") || strings.HasPrefix(word, "-") {
+			candidates := []string{}
+			for _, effect := range matches(ct.StatusEffects, word[1:]) {
+				candidates = append(candidates, word[:1]+effect)
+			}
+			return start, candidates
+		}
+
+		names := []string{}
+		for _, c := range ct.Combatants {
+			name := c.Name
+			if strings.Contains(name, " ") {
+				name = `"` + name + `"`
+			}
+			names = append(names, name)
+		}
+		return start, matches(names, strings.TrimPrefix(word, `"`))
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CurrentSaveVersion is the save format this build writes. Bump it, and add a
+// migration below, whenever old saves need changing to load correctly.
+const CurrentSaveVersion = "1.7.0"
+
+// oldestSaveVersion is assumed for saves that don't record a version
+const oldestSaveVersion = "1.0.0"
+
+// saveMigration upgrades a save from one version to the next. It works on the
+// raw JSON object, so it can see fields the current structs no longer have.
+// Versions that only add fields have no apply, older saves load as they are.
+type saveMigration struct {
+	from, to string
+	apply    func(state map[string]interface{}) error
+}
+
+// saveMigrations lists every upgrade step, oldest first
+var saveMigrations = []saveMigration{
+	{from: "1.0.0", to: "1.1.0", apply: migrate100To110},
+	{from: "1.1.0", to: "1.2.0"}, // Challenge ratings, XP and levels
+	{from: "1.2.0", to: "1.3.0"}, // Loot and the XP split
+	{from: "1.3.0", to: "1.4.0"}, // Combat statistics
+	{from: "1.4.0", to: "1.5.0"}, // Turn timers
+	{from: "1.5.0", to: "1.6.0"}, // Armor class
+	{from: "1.6.0", to: "1.7.0"}, // Awarded monsters
+}
+
+// migrate100To110 fills in the lists 1.0.0 saves could leave out: the status
+// effect catalogue and each combatant's status effects
+func migrate100To110(state map[string]interface{}) error {
+	tracker, ok := state["combatTracker"].(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("combatTracker is missing")
+	}
+
+	if effects, _ := tracker["statusEffects"].([]interface{}); len(effects) == 0 {
+		defaults := make([]interface{}, 0, len(defaultStatusEffects()))
+		for _, effect := range defaultStatusEffects() {
+			defaults = append(defaults, effect)
+		}
+		tracker["statusEffects"] = defaults
+	}
+
+	combatants, _ := tracker["combatants"].([]interface{})
+	for i, raw := range combatants {
+		c, ok := raw.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("combatTracker.combatants[%d] is not an object", i)
+		}
+		if _, ok := c["statusEffects"].([]interface{}); !ok {
+			c["statusEffects"] = []interface{}{}
+		}
+	}
+	return nil
+}
+
+// parseVersion splits a version like "1.2.0" into its numbers
+func parseVersion(version string) ([]int, error) {
+	parts := strings.Split(strings.TrimPrefix(version, "v"), ".")
+	numbers := make([]int, len(parts))
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid save version %q", version)
+		}
+		numbers[i] = n
+	}
+	return numbers, nil
+}
+
+// compareVersions returns -1, 0 or 1 as a is older than, the same as or newer than b
+func compareVersions(a, b string) (int, error) {
+	va, err := parseVersion(a)
+	if err != nil {
+		return 0, err
+	}
+	vb, err := parseVersion(b)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := 0; i < len(va) || i < len(vb); i++ {
+		x, y := 0, 0
+		if i < len(va) {
+			x = va[i]
+		}
+		if i < len(vb) {
+			y = vb[i]
+		}
+		if x != y {
+			if x < y {
+				return -1, nil
+			}
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+// migrationFrom finds the upgrade step for a save version. A version between
+// the ones listed, such as 1.0.5 from a patch release, is upgraded like the
+// nearest version below it: the steps only fill in what's missing, so they're
+// safe to run on a save that already has some of it.
+func migrationFrom(version string) (int, error) {
+	step := -1
+	for i, m := range saveMigrations {
+		cmp, err := compareVersions(m.from, version)
+		if err != nil {
+			return -1, err
+		}
+		if cmp > 0 {
+			break
+		}
+		step = i
+	}
+	if step < 0 {
+		return -1, fmt.Errorf("save format %s is older than any this tracker can upgrade, the oldest is %s", version, saveMigrations[0].from)
+	}
+	return step, nil
+}
+
+// migrateSave upgrades raw save data to CurrentSaveVersion. It returns the data
+// unchanged for current saves, and the version the save was written with.
+// Saves from a newer version are refused rather than loaded with fields lost.
+func migrateSave(data []byte) ([]byte, string, error) {
+	var state map[string]interface{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, "", fmt.Errorf("error parsing JSON: %v", err)
+	}
+
+	version, _ := state["version"].(string)
+	if version == "" {
+		version = oldestSaveVersion
+	}
+	original := version
+
+	cmp, err := compareVersions(version, CurrentSaveVersion)
+	if err != nil {
+		return nil, original, err
+	}
+	if cmp > 0 {
+		return nil, original, fmt.Errorf("save was written by a newer version of the tracker (save format %s, this tracker reads up to %s), please upgrade", version, CurrentSaveVersion)
+	}
+	if cmp == 0 {
+		return data, original, nil
+	}
+
+	for version != CurrentSaveVersion {
+		step, err := migrationFrom(version)
+		if err != nil {
+			return nil, original, err
+		}
+
+		m := saveMigrations[step]
+		if m.apply != nil {
+			if err := m.apply(state); err != nil {
+				return nil, original, fmt.Errorf("error upgrading save from %s to %s: %v", m.from, m.to, err)
+			}
+		}
+		version = m.to
+		state["version"] = version
+	}
+
+	migrated, err := json.Marshal(state)
+	if err != nil {
+		return nil, original, fmt.Errorf("error creating JSON: %v", err)
+	}
+	return migrated, original, nil
+}
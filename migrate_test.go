@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMigrateSaves upgrades a save from every past version in testdata/saves
+// and compares the result with the .golden file next to it
+func TestMigrateSaves(t *testing.T) {
+	saves, err := filepath.Glob(filepath.Join("testdata", "saves", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saves) == 0 {
+		t.Fatal("no saves in testdata/saves")
+	}
+
+	tested := map[string]bool{}
+	for _, path := range saves {
+		version := strings.TrimSuffix(filepath.Base(path), ".json")
+		tested[version] = true
+		t.Run(version, func(t *testing.T) {
+			data, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			migrated, original, err := migrateSave(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if original != version {
+				t.Errorf("save read as version %s", original)
+			}
+
+			var out bytes.Buffer
+			if err := json.Indent(&out, migrated, "", "    "); err != nil {
+				t.Fatal(err)
+			}
+			checkGolden(t, strings.TrimSuffix(path, ".json")+".golden", out.String()+"\n")
+
+			// The upgraded save loads without needing repairs
+			var state SaveState
+			if err := json.Unmarshal(migrated, &state); err != nil {
+				t.Fatal(err)
+			}
+			for _, problem := range state.CombatTracker.ValidateAndRepair() {
+				t.Errorf("upgraded save has a problem: %s", problem)
+			}
+		})
+	}
+
+	// Every version the tracker can upgrade from has a save to test
+	for _, m := range saveMigrations {
+		if !tested[m.from] {
+			t.Errorf("no save for version %s in testdata/saves", m.from)
+		}
+	}
+}
+
+func TestMigrateUnlistedVersion(t *testing.T) {
+	migrated, _, err := migrateSave([]byte(`{"combatTracker": {"combatants": [{"name": "Goblin"}]}, "version": "1.0.5"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state SaveState
+	if err := json.Unmarshal(migrated, &state); err != nil {
+		t.Fatal(err)
+	}
+	if state.Version != CurrentSaveVersion {
+		t.Errorf("upgraded to %s, want %s", state.Version, CurrentSaveVersion)
+	}
+	if len(state.CombatTracker.StatusEffects) == 0 {
+		t.Error("1.0.5 wasn't upgraded like 1.0.0")
+	}
+
+	if _, _, err := migrateSave([]byte(`{"combatTracker": {}, "version": "0.9.0"}`)); err == nil || !strings.Contains(err.Error(), "older than any") {
+		t.Errorf("0.9.0 gave %v", err)
+	}
+	if _, _, err := migrateSave([]byte(`{"combatTracker": {}, "version": "9.0.0"}`)); err == nil || !strings.Contains(err.Error(), "newer version") {
+		t.Errorf("9.0.0 gave %v", err)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Name styles for DuplicateCombatant copies
+const (
+	NameStyleNumbers    = "numbers"    // Goblin2, Goblin3, ...
+	NameStyleLetters    = "letters"    // Goblin A, Goblin B, ...
+	NameStyleAdjectives = "adjectives" // Scarred Goblin, Hulking Goblin, ...
+	NameStyleList       = "list"       // Names supplied by the user
+)
+
+// copyAdjectives is the pool NameStyleAdjectives draws from
+var copyAdjectives = []string{
+	"Scarred",
+	"Hulking",
+	"One-Eyed",
+	"Limping",
+	"Grizzled",
+	"Snarling",
+	"Wiry",
+	"Tattooed",
+	"Pale",
+	"Burly",
+	"Sly",
+	"Scrawny",
+	"Bloodied",
+	"Toothless",
+	"Cross-Eyed",
+	"Mangy",
+	"Hooded",
+	"Old",
+	"Young",
+	"Lanky",
+}
+
+// splitNameNumber splits a trailing run of digits off a name. "Goblin12" gives
+// ("Goblin", 12, true) and "Orc 2B" gives ("Orc 2B", 0, false); only digits at
+// the very end of the name count as a copy number.
+func splitNameNumber(name string) (string, int, bool) {
+	end := len(name)
+	for end > 0 && name[end-1] >= '0' && name[end-1] <= '9' {
+		end--
+	}
+	if end == len(name) || end == 0 {
+		return name, 0, false
+	}
+
+	number, err := strconv.Atoi(name[end:])
+	if err != nil {
+		return name, 0, false
+	}
+	return name[:end], number, true
+}
+
+// shuffledIndices returns 0 to n-1 in random order. It shuffles with rollDie, so
+// recorded scripts replay with the same names.
+func shuffledIndices(n int) []int {
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+	for i := n - 1; i > 0; i-- {
+		j := rollDie(i+1) - 1
+		order[i], order[j] = order[j], order[i]
+	}
+	return order
+}
+
+// letterSuffix converts 0, 1, ... 25, 26 into A, B, ... Z, AA
+func letterSuffix(n int) string {
+	suffix := ""
+	for n >= 0 {
+		suffix = string(rune('A'+n%26)) + suffix
+		n = n/26 - 1
+	}
+	return suffix
+}
+
+// copyNames picks count unused names for copies of the combatant called name
+func (ct *CombatTracker) copyNames(name string, count int, opts DuplicateOptions) []string {
+	taken := make(map[string]bool, len(ct.Combatants))
+	for _, c := range ct.Combatants {
+		taken[c.Name] = true
+	}
+
+	base, number, hasNumber := splitNameNumber(name)
+	if !hasNumber {
+		// The original counts as the first, so copies start at 2
+		number = 1
+	}
+	stem := strings.TrimSpace(base)
+
+	names := make([]string, 0, count)
+	claim := func(candidate string) bool {
+		if taken[candidate] {
+			return false
+		}
+		taken[candidate] = true
+		names = append(names, candidate)
+		return true
+	}
+
+	// nextNumbered hands out the next free numbered name, the fallback for every style
+	nextNumbered := func() {
+		for {
+			number++
+			if claim(fmt.Sprintf("%s%d", base, number)) {
+				return
+			}
+		}
+	}
+
+	switch opts.NameStyle {
+	case NameStyleLetters:
+		for n := 0; len(names) < count; n++ {
+			claim(fmt.Sprintf("%s %s", stem, letterSuffix(n)))
+		}
+
+	case NameStyleAdjectives:
+		for _, i := range shuffledIndices(len(copyAdjectives)) {
+			if len(names) == count {
+				break
+			}
+			claim(fmt.Sprintf("%s %s", copyAdjectives[i], stem))
+		}
+		for len(names) < count {
+			nextNumbered()
+		}
+
+	case NameStyleList:
+		for _, listed := range opts.Names {
+			if len(names) == count {
+				break
+			}
+			claim(listed)
+		}
+		for len(names) < count {
+			nextNumbered()
+		}
+
+	default:
+		for len(names) < count {
+			nextNumbered()
+		}
+	}
+
+	return names
+}
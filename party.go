@@ -0,0 +1,602 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// partyFileEnv names the environment variable that moves the party file
+const partyFileEnv = "COMBAT_TRACKER_PARTY"
+
+// partyFile is the party file given with -party, if any
+var partyFile string
+
+// Resource recharge periods
+const (
+	RechargeShortRest = "short"
+	RechargeLongRest  = "long"
+)
+
+// Party is the campaign's player characters, carried from one encounter to the next
+type Party struct {
+	Campaign string        `json:"campaign,omitempty"`
+	Members  []PartyMember `json:"members"`
+	Awards   []Award       `json:"awards,omitempty"` // XP and loot from each encounter, oldest first
+}
+
+// PartyMember is a player character's state between encounters
+type PartyMember struct {
+	Name            string         `json:"name"`
+	MaxHP           int            `json:"maxHP"`
+	CurrentHP       int            `json:"currentHP"`
+	TemporaryHP     int            `json:"temporaryHP"`
+	StatusEffects   []string       `json:"statusEffects"`
+	EffectDurations map[string]int `json:"effectDurations,omitempty"`
+	Alias           string         `json:"alias,omitempty"`
+	Level           int            `json:"level,omitempty"`
+	AC              int            `json:"ac,omitempty"`
+	XP              int            `json:"xp,omitempty"`          // Experience earned so far
+	HitDie          string         `json:"hitDie,omitempty"`      // Rolled for each hit die spent on a short rest, e.g. "d10+2"
+	HitDice         int            `json:"hitDice,omitempty"`     // Hit dice when fully rested
+	HitDiceLeft     int            `json:"hitDiceLeft,omitempty"` // Hit dice not yet spent
+	Resources       []Resource     `json:"resources,omitempty"`
+	TurnBudget      int            `json:"turnBudget,omitempty"` // Seconds per turn, overriding the tracker's limit
+	TimeSpent       int            `json:"timeSpent,omitempty"`  // Seconds spent on turns across encounters
+}
+
+// Resource is a limited use a rest restores, such as ki points or a spell slot level
+type Resource struct {
+	Name     string `json:"name"`
+	Max      int    `json:"max"`
+	Left     int    `json:"left"`
+	Recharge string `json:"recharge"` // RechargeShortRest or RechargeLongRest
+}
+
+// partyPath is the party file for the campaign
+func partyPath() string {
+	if partyFile != "" {
+		return partyFile
+	}
+	if path := os.Getenv(partyFileEnv); path != "" {
+		return path
+	}
+	return "party.json"
+}
+
+// partyBeside makes the party file default to party.json next to the save file,
+// so a campaign's party is found wherever the tracker is started from. -party
+// and the environment variable still take precedence.
+func partyBeside(saveFile string) {
+	if partyFile == "" && os.Getenv(partyFileEnv) == "" && saveFile != "" {
+		partyFile = filepath.Join(filepath.Dir(saveFile), "party.json")
+	}
+}
+
+// LoadParty reads the party file. A missing file is an empty party.
+func LoadParty() (*Party, error) {
+	data, err := os.ReadFile(partyPath())
+	if os.IsNotExist(err) {
+		return &Party{Members: []PartyMember{}}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading party: %v", err)
+	}
+
+	var party Party
+	if err := json.Unmarshal(data, &party); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %v", partyPath(), err)
+	}
+	return &party, nil
+}
+
+// Save writes the party file
+func (p *Party) Save() error {
+	data, err := json.MarshalIndent(p, "", "    ")
+	if err != nil {
+		return fmt.Errorf("error creating JSON: %v", err)
+	}
+	if err := writeFileAtomic(partyPath(), data, 0644); err != nil {
+		return fmt.Errorf("error writing party: %v", err)
+	}
+	return nil
+}
+
+// member finds a party member by name, ignoring case
+func (p *Party) member(name string) *PartyMember {
+	for i := range p.Members {
+		if strings.EqualFold(p.Members[i].Name, name) {
+			return &p.Members[i]
+		}
+	}
+	return nil
+}
+
+// takeState copies a combatant's HP, conditions and turn times into the party member
+func (m *PartyMember) takeState(c *Combatant) {
+	m.MaxHP = c.MaxHP
+	m.CurrentHP = c.CurrentHP
+	m.TemporaryHP = c.TemporaryHP
+	m.StatusEffects = append([]string{}, c.StatusEffects...)
+	m.EffectDurations = nil
+	for effect, rounds := range c.EffectDurations {
+		if m.EffectDurations == nil {
+			m.EffectDurations = make(map[string]int)
+		}
+		m.EffectDurations[effect] = rounds
+	}
+	m.Alias = c.Alias
+	m.Level = c.Level
+	m.AC = c.AC
+	m.TurnBudget = c.TurnBudget
+	m.TimeSpent = c.TimeSpent
+}
+
+// giveState copies the party member's HP, conditions and turn times onto a combatant
+func (m *PartyMember) giveState(c *Combatant) {
+	c.MaxHP = m.MaxHP
+	c.CurrentHP = m.CurrentHP
+	c.TemporaryHP = m.TemporaryHP
+	c.StatusEffects = append([]string{}, m.StatusEffects...)
+	c.EffectDurations = nil
+	for effect, rounds := range m.EffectDurations {
+		if c.EffectDurations == nil {
+			c.EffectDurations = make(map[string]int)
+		}
+		c.EffectDurations[effect] = rounds
+	}
+	c.Alias = m.Alias
+	c.Level = m.Level
+	c.AC = m.AC
+	c.TurnBudget = m.TurnBudget
+	c.TimeSpent = m.TimeSpent
+	c.IsConscious = c.CurrentHP > 0
+}
+
+// AddToParty adds player characters to the party, or updates the ones already in it
+func (ct *CombatTracker) AddToParty(indices []int) error {
+	party, err := LoadParty()
+	if err != nil {
+		return err
+	}
+
+	for _, index := range indices {
+		c := &ct.Combatants[index]
+		if !c.IsPlayer {
+			return fmt.Errorf("%s is not a player character", c.Name)
+		}
+	}
+	for _, index := range indices {
+		c := &ct.Combatants[index]
+		m := party.member(c.Name)
+		if m == nil {
+			party.Members = append(party.Members, PartyMember{Name: c.Name})
+			m = &party.Members[len(party.Members)-1]
+			ct.printf("%s joins the party.\n", c.Name)
+		} else {
+			ct.printf("Updated %s in the party.\n", c.Name)
+		}
+		m.takeState(c)
+	}
+	if party.Campaign == "" {
+		party.Campaign = ct.CampaignName
+	}
+	return party.Save()
+}
+
+// RemoveFromParty takes a player character out of the party
+func (ct *CombatTracker) RemoveFromParty(name string) error {
+	party, err := LoadParty()
+	if err != nil {
+		return err
+	}
+
+	m := party.member(name)
+	if m == nil {
+		return fmt.Errorf("no party member named %q", name)
+	}
+	removed := m.Name
+	remaining := []PartyMember{}
+	for _, member := range party.Members {
+		if member.Name != removed {
+			remaining = append(remaining, member)
+		}
+	}
+	party.Members = remaining
+	ct.printf("%s leaves the party.\n", removed)
+	return party.Save()
+}
+
+// ShowParty lists the party with hit dice and resources
+func (ct *CombatTracker) ShowParty() error {
+	party, err := LoadParty()
+	if err != nil {
+		return err
+	}
+	if len(party.Members) == 0 {
+		ct.printf("No party in %s yet, use party add players to start one.\n", partyPath())
+		return nil
+	}
+
+	title := "Party"
+	if party.Campaign != "" {
+		title = party.Campaign + " party"
+	}
+	ct.printf("\n===== %s (%s) =====\n", title, partyPath())
+	for _, m := range party.Members {
+		tempHPStr := ""
+		if m.TemporaryHP > 0 {
+			tempHPStr = fmt.Sprintf(" (+%d temp)", m.TemporaryHP)
+		}
+		ct.printf("%-20s HP: %3d/%-3d%s", m.Name, m.CurrentHP, m.MaxHP, tempHPStr)
+		if m.Level > 0 || m.XP > 0 {
+			ct.printf("  Level %d, %s XP", m.Level, formatXP(m.XP))
+		}
+		if m.HitDice > 0 {
+			ct.printf("  Hit dice: %d/%d %s", m.HitDiceLeft, m.HitDice, m.HitDie)
+		}
+		ct.println()
+		if len(m.StatusEffects) > 0 {
+			ct.printf("    Effects: %s\n", strings.Join(m.StatusEffects, ", "))
+		}
+		for _, r := range m.Resources {
+			ct.printf("    %s: %d/%d (%s rest)\n", r.Name, r.Left, r.Max, r.Recharge)
+		}
+	}
+	return nil
+}
+
+// PullParty brings the party into the encounter. Members missing from the
+// tracker are added with a d20 rolled for initiative, and with refresh the ones
+// already in it take their state from the party file too. Nothing happens
+// without a party file.
+func (ct *CombatTracker) PullParty(refresh bool) {
+	party, err := LoadParty()
+	if err != nil {
+		ct.printf("Could not load the party: %v\n", err)
+		return
+	}
+	if len(party.Members) == 0 {
+		return
+	}
+
+	if ct.CampaignName == "" {
+		ct.CampaignName = party.Campaign
+	}
+	joined := []string{}
+	for i := range party.Members {
+		m := &party.Members[i]
+		index := ct.indexByName(m.Name)
+		if index < 0 {
+			initiative := rollDie(20)
+			ct.Combatants = append(ct.Combatants, Combatant{Name: m.Name, Initiative: initiative, IsPlayer: true})
+			index = len(ct.Combatants) - 1
+			joined = append(joined, fmt.Sprintf("%s (Init: %d)", m.Name, initiative))
+		} else if !refresh || !ct.Combatants[index].IsPlayer {
+			continue
+		}
+		m.giveState(&ct.Combatants[index])
+	}
+	if len(joined) > 0 {
+		ct.printf("From the party: %s\n", strings.Join(joined, ", "))
+		ct.println("Initiative was rolled for them, change it with init <target> <value>.")
+		ct.record(fmt.Sprintf("%s join the encounter", strings.Join(joined, ", ")))
+	}
+}
+
+// saveParty writes the state of every party member in the tracker back to the party file
+func (ct *CombatTracker) saveParty() {
+	party, err := LoadParty()
+	if err != nil {
+		ct.printf("Could not save the party: %v\n", err)
+		return
+	}
+	if len(party.Members) == 0 {
+		return
+	}
+
+	saved := 0
+	for i := range party.Members {
+		index := ct.indexByName(party.Members[i].Name)
+		if index >= 0 && ct.Combatants[index].IsPlayer {
+			party.Members[i].takeState(&ct.Combatants[index])
+			saved++
+		}
+	}
+	if saved == 0 {
+		return
+	}
+	if err := party.Save(); err != nil {
+		ct.printf("Could not save the party: %v\n", err)
+		return
+	}
+	ct.printf("Saved %d party member(s) to %s.\n", saved, partyPath())
+}
+
+// Rest gives the party a short or long rest. On a short rest, spend says how
+// many hit dice each named member rolls to heal. A long rest restores HP,
+// half the hit dice and every resource, and ends timed effects.
+func (ct *CombatTracker) Rest(long bool, spend map[string]int) error {
+	if ct.IsActive {
+		return fmt.Errorf("end combat before resting")
+	}
+	party, err := LoadParty()
+	if err != nil {
+		return err
+	}
+	if len(party.Members) == 0 {
+		return fmt.Errorf("no party in %s, use party add players to start one", partyPath())
+	}
+
+	// The tracker has the latest HP of anyone in the encounter
+	for i := range party.Members {
+		if index := ct.indexByName(party.Members[i].Name); index >= 0 && ct.Combatants[index].IsPlayer {
+			party.Members[i].takeState(&ct.Combatants[index])
+		}
+	}
+	for name, dice := range spend {
+		m := party.member(name)
+		if m == nil {
+			return fmt.Errorf("no party member named %q", name)
+		}
+		if m.HitDie == "" {
+			return fmt.Errorf("%s has no hit die set, use party hitdice", m.Name)
+		}
+		if dice > m.HitDiceLeft {
+			return fmt.Errorf("%s has only %d hit dice left", m.Name, m.HitDiceLeft)
+		}
+	}
+
+	if long {
+		ct.println("\n===== LONG REST =====")
+	} else {
+		ct.println("\n===== SHORT REST =====")
+	}
+	for i := range party.Members {
+		m := &party.Members[i]
+		if long {
+			m.CurrentHP = m.MaxHP
+			m.TemporaryHP = 0
+			regained := m.HitDice / 2
+			if regained < 1 && m.HitDice > 0 {
+				regained = 1
+			}
+			m.HitDiceLeft += regained
+			if m.HitDiceLeft > m.HitDice {
+				m.HitDiceLeft = m.HitDice
+			}
+			effects := []string{}
+			for _, effect := range m.StatusEffects {
+				if _, timed := m.EffectDurations[effect]; !timed {
+					effects = append(effects, effect)
+				}
+			}
+			m.StatusEffects = effects
+			m.EffectDurations = nil
+			ct.printf("%s is back to %d HP.\n", m.Name, m.CurrentHP)
+		} else if dice := spend[strings.ToLower(m.Name)]; dice > 0 {
+			if err := ct.spendHitDice(m, dice); err != nil {
+				return err
+			}
+		}
+
+		for j := range m.Resources {
+			r := &m.Resources[j]
+			if long || r.Recharge == RechargeShortRest {
+				r.Left = r.Max
+			}
+		}
+	}
+
+	if err := party.Save(); err != nil {
+		return err
+	}
+	for i := range party.Members {
+		if index := ct.indexByName(party.Members[i].Name); index >= 0 && ct.Combatants[index].IsPlayer {
+			party.Members[i].giveState(&ct.Combatants[index])
+		}
+	}
+	if long {
+		ct.record("The party takes a long rest")
+	} else {
+		ct.record("The party takes a short rest")
+	}
+
+	// Auto-save state
+	ct.AutoSave()
+	return nil
+}
+
+// spendHitDice rolls hit dice to heal a party member on a short rest
+func (ct *CombatTracker) spendHitDice(m *PartyMember, dice int) error {
+	formula, err := ParseDice(m.HitDie)
+	if err != nil {
+		return fmt.Errorf("%s hit die: %v", m.Name, err)
+	}
+
+	healed := 0
+	for i := 0; i < dice; i++ {
+		total, rolls := formula.Roll()
+		if total < 0 {
+			total = 0
+		}
+		ct.printf("%s rolls %s: %d %v\n", m.Name, formula, total, rolls)
+		healed += total
+	}
+	m.HitDiceLeft -= dice
+	m.CurrentHP += healed
+	if m.CurrentHP > m.MaxHP {
+		m.CurrentHP = m.MaxHP
+	}
+	ct.printf("%s spends %d hit dice and is at %d/%d HP.\n", m.Name, dice, m.CurrentHP, m.MaxHP)
+	return nil
+}
+
+// UseResource spends uses of a party member's resource
+func (ct *CombatTracker) UseResource(name, resource string, uses int) error {
+	party, err := LoadParty()
+	if err != nil {
+		return err
+	}
+	m := party.member(name)
+	if m == nil {
+		return fmt.Errorf("no party member named %q", name)
+	}
+
+	for i := range m.Resources {
+		r := &m.Resources[i]
+		if !strings.EqualFold(r.Name, resource) {
+			continue
+		}
+		if uses > r.Left {
+			return fmt.Errorf("%s has only %d %s left", m.Name, r.Left, r.Name)
+		}
+		r.Left -= uses
+		ct.printf("%s uses %d %s, %d/%d left.\n", m.Name, uses, r.Name, r.Left, r.Max)
+		return party.Save()
+	}
+	return fmt.Errorf("%s has no resource called %q", m.Name, resource)
+}
+
+// SetResource adds a resource to a party member or changes its maximum and recharge
+func (ct *CombatTracker) SetResource(name, resource string, max int, recharge string) error {
+	party, err := LoadParty()
+	if err != nil {
+		return err
+	}
+	m := party.member(name)
+	if m == nil {
+		return fmt.Errorf("no party member named %q", name)
+	}
+
+	for i := range m.Resources {
+		if strings.EqualFold(m.Resources[i].Name, resource) {
+			m.Resources[i] = Resource{Name: m.Resources[i].Name, Max: max, Left: max, Recharge: recharge}
+			ct.printf("%s now has %d %s per %s rest.\n", m.Name, max, m.Resources[i].Name, recharge)
+			return party.Save()
+		}
+	}
+	m.Resources = append(m.Resources, Resource{Name: resource, Max: max, Left: max, Recharge: recharge})
+	sort.SliceStable(m.Resources, func(i, j int) bool { return m.Resources[i].Name < m.Resources[j].Name })
+	ct.printf("%s now has %d %s per %s rest.\n", m.Name, max, resource, recharge)
+	return party.Save()
+}
+
+// SetHitDice sets a party member's hit dice, all of them unspent
+func (ct *CombatTracker) SetHitDice(name string, count int, die string) error {
+	if _, err := ParseDice(die); err != nil {
+		return err
+	}
+	party, err := LoadParty()
+	if err != nil {
+		return err
+	}
+	m := party.member(name)
+	if m == nil {
+		return fmt.Errorf("no party member named %q", name)
+	}
+
+	m.HitDie = die
+	m.HitDice = count
+	m.HitDiceLeft = count
+	ct.printf("%s has %d hit dice of %s.\n", m.Name, count, die)
+	return party.Save()
+}
+
+func cmdParty(ct *CombatTracker, args []string) error {
+	usage := fmt.Errorf(`usage: party [add <targets> | remove <name> | hitdice <name> <count> <die> | resource <name> "<resource>" <max> short|long]`)
+	if len(args) == 0 {
+		return ct.ShowParty()
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "add":
+		if len(args) != 2 {
+			return usage
+		}
+		indices, err := ct.resolveTargetList(args[1])
+		if err != nil {
+			return err
+		}
+		return ct.AddToParty(indices)
+	case "remove":
+		if len(args) != 2 {
+			return usage
+		}
+		return ct.RemoveFromParty(args[1])
+	case "hitdice":
+		if len(args) != 4 {
+			return usage
+		}
+		count, err := strconv.Atoi(args[2])
+		if err != nil || count < 0 {
+			return fmt.Errorf("invalid number of hit dice %q", args[2])
+		}
+		return ct.SetHitDice(args[1], count, args[3])
+	case "resource":
+		if len(args) != 5 {
+			return usage
+		}
+		max, err := strconv.Atoi(args[3])
+		if err != nil || max < 0 {
+			return fmt.Errorf("invalid maximum %q", args[3])
+		}
+		recharge := strings.ToLower(args[4])
+		if recharge != RechargeShortRest && recharge != RechargeLongRest {
+			return fmt.Errorf("expected short or long, got %q", args[4])
+		}
+		return ct.SetResource(args[1], args[2], max, recharge)
+	default:
+		return usage
+	}
+}
+
+func cmdRest(ct *CombatTracker, args []string) error {
+	usage := fmt.Errorf("usage: rest long | rest short [<name> <hit dice>]...")
+	if len(args) == 0 {
+		return usage
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "long":
+		if len(args) != 1 {
+			return usage
+		}
+		return ct.Rest(true, nil)
+	case "short":
+		if len(args)%2 != 1 {
+			return usage
+		}
+		spend := make(map[string]int)
+		for i := 1; i < len(args); i += 2 {
+			dice, err := strconv.Atoi(args[i+1])
+			if err != nil || dice < 1 {
+				return fmt.Errorf("invalid number of hit dice %q", args[i+1])
+			}
+			spend[strings.ToLower(args[i])] = dice
+		}
+		return ct.Rest(false, spend)
+	default:
+		return usage
+	}
+}
+
+func cmdUse(ct *CombatTracker, args []string) error {
+	if len(args) < 2 || len(args) > 3 {
+		return fmt.Errorf(`usage: use <name> "<resource>" [uses]`)
+	}
+
+	uses := 1
+	if len(args) == 3 {
+		var err error
+		uses, err = strconv.Atoi(args[2])
+		if err != nil || uses < 1 {
+			return fmt.Errorf("invalid number of uses %q", args[2])
+		}
+	}
+	return ct.UseResource(args[0], args[1], uses)
+}
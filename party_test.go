@@ -0,0 +1,61 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStartRollsInitiativeForParty(t *testing.T) {
+	ct, out := newTestTracker(t)
+	ct.AddCombatant("Thorin", 15, 30, true)
+	ct.AddCombatant("Mira", 12, 22, true)
+	if err := ct.AddToParty([]int{0, 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The next encounter starts without them
+	ct = NewCombatTracker()
+	out.Reset()
+	ct.SetOutput(out)
+	ct.AddCombatant("Goblin", 10, 7, false)
+	rollDie = func(sides int) int { return 14 }
+	ct.StartCombat()
+
+	for _, c := range ct.Combatants {
+		if c.IsPlayer && c.Initiative != 14 {
+			t.Errorf("%s joined with initiative %d, want the 14 rolled", c.Name, c.Initiative)
+		}
+	}
+	if ct.Combatants[len(ct.Combatants)-1].Name != "Goblin" {
+		t.Errorf("the order wasn't sorted after the party joined: %v", ct.Combatants)
+	}
+	if !strings.Contains(out.String(), "From the party: Thorin (Init: 14), Mira (Init: 14)") {
+		t.Errorf("output doesn't say who joined:\n%s", out)
+	}
+}
+
+func TestPartyBesideSaveFile(t *testing.T) {
+	previous := partyFile
+	t.Cleanup(func() { partyFile = previous })
+	t.Setenv(partyFileEnv, "")
+
+	partyFile = ""
+	partyBeside(filepath.Join("campaign", "session3.json"))
+	if want := filepath.Join("campaign", "party.json"); partyFile != want {
+		t.Errorf("party file is %q, want %q", partyFile, want)
+	}
+
+	partyFile = "mine.json"
+	partyBeside(filepath.Join("campaign", "session3.json"))
+	if partyFile != "mine.json" {
+		t.Errorf("-party was replaced with %q", partyFile)
+	}
+
+	partyFile = ""
+	t.Setenv(partyFileEnv, "env.json")
+	partyBeside(filepath.Join("campaign", "session3.json"))
+	if partyFile != "" {
+		t.Errorf("$%s was overridden with %q", partyFileEnv, partyFile)
+	}
+}
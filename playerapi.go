@@ -0,0 +1,424 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Kinds of update a player can send
+const (
+	UpdateDamage    = "damage"
+	UpdateHeal      = "heal"
+	UpdateTemp      = "temp"
+	UpdateCondition = "condition"
+)
+
+// maxUpdateBody limits the size of a player update request
+const maxUpdateBody = 4096
+
+// PlayerUpdate is a change a player reported for their own character through the API
+type PlayerUpdate struct {
+	ID         int    `json:"id"`
+	Combatant  string `json:"combatant"`
+	Kind       string `json:"kind"`
+	Amount     int    `json:"amount,omitempty"`
+	DamageType string `json:"damageType,omitempty"`
+	Effect     string `json:"effect,omitempty"`
+	Remove     bool   `json:"remove,omitempty"`
+	Rounds     int    `json:"rounds,omitempty"`
+}
+
+// String describes the update for the GM, e.g. "Thorin takes 7 fire damage"
+func (u PlayerUpdate) String() string {
+	switch u.Kind {
+	case UpdateDamage:
+		if u.DamageType != "" {
+			return fmt.Sprintf("%s takes %d %s damage", u.Combatant, u.Amount, u.DamageType)
+		}
+		return fmt.Sprintf("%s takes %d damage", u.Combatant, u.Amount)
+	case UpdateHeal:
+		return fmt.Sprintf("%s heals %d", u.Combatant, u.Amount)
+	case UpdateTemp:
+		return fmt.Sprintf("%s gains %d temporary HP", u.Combatant, u.Amount)
+	case UpdateCondition:
+		if u.Remove {
+			return fmt.Sprintf("%s is no longer %s", u.Combatant, u.Effect)
+		}
+		if u.Rounds > 0 {
+			return fmt.Sprintf("%s is %s for %d round(s)", u.Combatant, u.Effect, u.Rounds)
+		}
+		return fmt.Sprintf("%s is %s", u.Combatant, u.Effect)
+	}
+	return fmt.Sprintf("%s: unknown update %q", u.Combatant, u.Kind)
+}
+
+// validate checks the fields a player filled in
+func (u PlayerUpdate) validate() error {
+	switch u.Kind {
+	case UpdateDamage, UpdateHeal, UpdateTemp:
+		if u.Amount <= 0 {
+			return fmt.Errorf("amount must be a positive number")
+		}
+	case UpdateCondition:
+		if strings.TrimSpace(u.Effect) == "" {
+			return fmt.Errorf("effect is required")
+		}
+		if u.Rounds < 0 {
+			return fmt.Errorf("rounds can't be negative")
+		}
+	default:
+		return fmt.Errorf("unknown update %q", u.Kind)
+	}
+	return nil
+}
+
+// CreatePlayerToken gives the combatant a new API token, replacing any old one
+func (ct *CombatTracker) CreatePlayerToken(index int) {
+	if index < 0 || index >= len(ct.Combatants) {
+		ct.println("Invalid combatant index!")
+		return
+	}
+
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		ct.printf("Error creating token: %v\n", err)
+		return
+	}
+
+	name := ct.Combatants[index].Name
+	ct.revokePlayerTokens(name)
+	if ct.PlayerTokens == nil {
+		ct.PlayerTokens = make(map[string]string)
+	}
+	token := hex.EncodeToString(secret)
+	ct.PlayerTokens[token] = name
+	ct.printf("Token for %s: %s\n", name, token)
+
+	// Auto-save state
+	ct.AutoSave()
+}
+
+// RevokePlayerToken removes the combatant's API token
+func (ct *CombatTracker) RevokePlayerToken(index int) {
+	if index < 0 || index >= len(ct.Combatants) {
+		ct.println("Invalid combatant index!")
+		return
+	}
+
+	name := ct.Combatants[index].Name
+	if !ct.revokePlayerTokens(name) {
+		ct.printf("%s has no token.\n", name)
+		return
+	}
+	ct.printf("Revoked the token for %s.\n", name)
+
+	// Auto-save state
+	ct.AutoSave()
+}
+
+func (ct *CombatTracker) revokePlayerTokens(name string) bool {
+	revoked := false
+	for token, owner := range ct.PlayerTokens {
+		if owner == name {
+			delete(ct.PlayerTokens, token)
+			revoked = true
+		}
+	}
+	return revoked
+}
+
+// tokenOwner returns the combatant a token belongs to, comparing in constant time
+func (ct *CombatTracker) tokenOwner(token string) (int, error) {
+	owner := ""
+	for candidate, name := range ct.PlayerTokens {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(token)) == 1 {
+			owner = name
+		}
+	}
+	if owner == "" {
+		return -1, fmt.Errorf("unknown token")
+	}
+
+	index := ct.indexByName(owner)
+	if index < 0 {
+		return -1, fmt.Errorf("%s is no longer in the encounter", owner)
+	}
+	return index, nil
+}
+
+// SubmitPlayerUpdate applies a player's update, or queues it for the GM when
+// approval is required. It reports whether the update was applied.
+func (ct *CombatTracker) SubmitPlayerUpdate(update PlayerUpdate) (PlayerUpdate, bool, error) {
+	if err := update.validate(); err != nil {
+		return update, false, err
+	}
+	if update.Kind == UpdateCondition {
+		update.Effect = ct.catalogEffect(strings.TrimSpace(update.Effect))
+	}
+
+	if !ct.RequireApproval {
+		ct.printf("Player update: %s\n", update)
+		return update, true, ct.applyPlayerUpdate(update)
+	}
+
+	update.ID = 1
+	for _, pending := range ct.PendingUpdates {
+		if pending.ID >= update.ID {
+			update.ID = pending.ID + 1
+		}
+	}
+	ct.PendingUpdates = append(ct.PendingUpdates, update)
+	ct.printf("Player update #%d waiting for approval: %s (approve %d / reject %d)\n", update.ID, update, update.ID, update.ID)
+
+	// Auto-save state
+	ct.AutoSave()
+	return update, false, nil
+}
+
+// applyPlayerUpdate makes the change through the same methods the GM uses
+func (ct *CombatTracker) applyPlayerUpdate(update PlayerUpdate) error {
+	index := ct.indexByName(update.Combatant)
+	if index < 0 {
+		return fmt.Errorf("%s is no longer in the encounter", update.Combatant)
+	}
+
+	switch update.Kind {
+	case UpdateDamage:
+		ct.AdjustHP(index, -update.Amount)
+	case UpdateHeal:
+		ct.AdjustHP(index, update.Amount)
+	case UpdateTemp:
+		ct.AddTemporaryHP(index, update.Amount)
+	case UpdateCondition:
+		effect := update.Effect
+		switch {
+		case update.Remove:
+			ct.RemoveStatusEffect(index, ct.appliedEffect(index, effect))
+		case update.Rounds > 0:
+			ct.AddTimedStatusEffect(index, effect, update.Rounds)
+		default:
+			ct.AddStatusEffect(index, effect)
+		}
+	}
+	return nil
+}
+
+// SetRequireApproval turns GM approval of player updates on or off
+func (ct *CombatTracker) SetRequireApproval(required bool) {
+	ct.RequireApproval = required
+	if required {
+		ct.println("Player updates now wait for approval.")
+	} else {
+		ct.println("Player updates are applied straight away.")
+	}
+
+	// Auto-save state
+	ct.AutoSave()
+}
+
+// ResolvePendingUpdates approves or rejects waiting player updates, selected by
+// number or with "all"
+func (ct *CombatTracker) ResolvePendingUpdates(selector string, approve bool) error {
+	if len(ct.PendingUpdates) == 0 {
+		return fmt.Errorf("no player updates are waiting")
+	}
+
+	id := 0
+	if strings.ToLower(selector) != "all" {
+		var err error
+		id, err = strconv.Atoi(strings.TrimPrefix(selector, "#"))
+		if err != nil {
+			return fmt.Errorf("expected an update number or all, got %q", selector)
+		}
+	}
+
+	var remaining []PlayerUpdate
+	var selected []PlayerUpdate
+	for _, update := range ct.PendingUpdates {
+		if id == 0 || update.ID == id {
+			selected = append(selected, update)
+		} else {
+			remaining = append(remaining, update)
+		}
+	}
+	if len(selected) == 0 {
+		return fmt.Errorf("no player update #%d", id)
+	}
+	ct.PendingUpdates = remaining
+
+	for _, update := range selected {
+		if !approve {
+			ct.printf("Rejected #%d: %s\n", update.ID, update)
+			continue
+		}
+		ct.printf("Approved #%d: %s\n", update.ID, update)
+		if err := ct.applyPlayerUpdate(update); err != nil {
+			ct.println(err)
+		}
+	}
+
+	// Auto-save state
+	ct.AutoSave()
+	return nil
+}
+
+func cmdToken(ct *CombatTracker, args []string) error {
+	if len(args) == 0 {
+		if len(ct.PlayerTokens) == 0 {
+			ct.println("No player tokens. Create one with: token <target>")
+			return nil
+		}
+		owners := make([]string, 0, len(ct.PlayerTokens))
+		for token, name := range ct.PlayerTokens {
+			owners = append(owners, fmt.Sprintf("%s: %s", name, token))
+		}
+		sort.Strings(owners)
+		for _, line := range owners {
+			ct.println(line)
+		}
+		return nil
+	}
+	if len(args) > 2 || (len(args) == 2 && strings.ToLower(args[1]) != "off") {
+		return fmt.Errorf("usage: token [<target> [off]]")
+	}
+
+	index, err := ct.ResolveTarget(args[0])
+	if err != nil {
+		return err
+	}
+	if len(args) == 2 {
+		ct.RevokePlayerToken(index)
+		return nil
+	}
+	ct.CreatePlayerToken(index)
+	return nil
+}
+
+func cmdApproval(ct *CombatTracker, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: approval on|off")
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "on":
+		ct.SetRequireApproval(true)
+	case "off":
+		ct.SetRequireApproval(false)
+	default:
+		return fmt.Errorf("usage: approval on|off")
+	}
+	return nil
+}
+
+func cmdPending(ct *CombatTracker, args []string) error {
+	if len(ct.PendingUpdates) == 0 {
+		ct.println("No player updates are waiting.")
+		return nil
+	}
+	for _, update := range ct.PendingUpdates {
+		ct.printf("#%d: %s\n", update.ID, update)
+	}
+	return nil
+}
+
+func cmdApprove(ct *CombatTracker, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: approve <number>|all")
+	}
+	return ct.ResolvePendingUpdates(args[0], true)
+}
+
+func cmdReject(ct *CombatTracker, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: reject <number>|all")
+	}
+	return ct.ResolvePendingUpdates(args[0], false)
+}
+
+// EnableWrites lets players change their own characters in the session through the API
+func (s *Server) EnableWrites(session *Session) {
+	s.session = session
+}
+
+// registerPlayerAPI adds the endpoints players use with their tokens
+func (s *Server) registerPlayerAPI(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/me", s.withPlayer(func(w http.ResponseWriter, r *http.Request, ct *CombatTracker, index int) {
+		writeJSON(w, ct.combatantView(index, true))
+	}))
+
+	for _, kind := range []string{UpdateDamage, UpdateHeal, UpdateTemp, UpdateCondition} {
+		kind := kind
+		mux.HandleFunc("POST /api/me/"+kind, func(w http.ResponseWriter, r *http.Request) {
+			// Read the update before taking the session, so a slow client holds up no one else
+			var update PlayerUpdate
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxUpdateBody)).Decode(&update); err != nil {
+				writeJSONError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+				return
+			}
+			update.ID = 0
+			update.Kind = kind
+			if err := update.validate(); err != nil {
+				writeJSONError(w, http.StatusBadRequest, err.Error())
+				return
+			}
+
+			s.withPlayer(func(w http.ResponseWriter, r *http.Request, ct *CombatTracker, index int) {
+				update.Combatant = ct.Combatants[index].Name
+				update, applied, err := ct.SubmitPlayerUpdate(update)
+				if err != nil {
+					writeJSONError(w, http.StatusBadRequest, err.Error())
+					return
+				}
+				ct.changed()
+
+				if !applied {
+					writeJSONStatus(w, http.StatusAccepted, map[string]interface{}{"status": "pending", "update": update})
+					return
+				}
+				writeJSON(w, map[string]interface{}{"status": "applied", "update": update})
+			})(w, r)
+		})
+	}
+}
+
+// withPlayer checks the bearer token and runs handler with the session held and
+// the index of the token's combatant. Handlers get their request body read before
+// this, so the session is only held for the change itself.
+func (s *Server) withPlayer(handler func(w http.ResponseWriter, r *http.Request, ct *CombatTracker, index int)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.session == nil {
+			writeJSONError(w, http.StatusForbidden, "player updates are turned off")
+			return
+		}
+
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeJSONError(w, http.StatusUnauthorized, "missing bearer token")
+			return
+		}
+
+		s.session.Lock()
+		defer s.session.Unlock()
+
+		ct := s.session.Tracker()
+		index, err := ct.tokenOwner(strings.TrimSpace(token))
+		if err != nil {
+			writeJSONError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+		handler(w, r, ct, index)
+	}
+}
+
+// writeJSONError writes an error response as {"error": message}
+func writeJSONError(w http.ResponseWriter, status int, message string) {
+	writeJSONStatus(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestServer serves the player API for a session with Thorin, whose token is "thorin-token"
+func newTestServer(t *testing.T) (*Session, *httptest.Server) {
+	t.Helper()
+	ct, _ := newTestTracker(t)
+	ct.AddCombatant("Thorin", 15, 30, true)
+	ct.AddCombatant("Goblin", 10, 7, false)
+	ct.PlayerTokens = map[string]string{"thorin-token": "Thorin"}
+
+	session := NewSession(ct)
+	server := NewServer(false)
+	server.EnableWrites(session)
+	ct.SetOnChange(server.Publish)
+	ts := httptest.NewServer(server.Handler())
+	t.Cleanup(ts.Close)
+	return session, ts
+}
+
+func TestSlowPlayerUpdateDoesNotHoldSession(t *testing.T) {
+	session, ts := newTestServer(t)
+
+	body, write := io.Pipe()
+	req, err := http.NewRequest("POST", ts.URL+"/api/me/damage", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer thorin-token")
+
+	responses := make(chan *http.Response, 1)
+	go func() {
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Error(err)
+			close(responses)
+			return
+		}
+		responses <- resp
+	}()
+
+	// Half the update arrives, then the client stalls
+	if _, err := write.Write([]byte(`{"amount": `)); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(200 * time.Millisecond) // Let the handler start reading
+
+	done := make(chan struct{})
+	go func() {
+		session.Do(func(ct *CombatTracker) { ct.AdjustHP(1, -2) })
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		write.CloseWithError(io.ErrUnexpectedEOF)
+		t.Fatal("the session was held while the update was still arriving")
+	}
+
+	write.Write([]byte(`5}`))
+	write.Close()
+	resp := <-responses
+	if resp == nil {
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want 200", resp.StatusCode)
+	}
+	if hp := session.Snapshot().Combatants[0].CurrentHP; hp != 25 {
+		t.Errorf("Thorin has %d HP, want 25", hp)
+	}
+}
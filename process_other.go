@@ -0,0 +1,11 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+
+package main
+
+import "os"
+
+// processAlive reports whether a process with the given PID is running
+func processAlive(pid int) bool {
+	_, err := os.FindProcess(pid)
+	return err == nil
+}
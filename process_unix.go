@@ -0,0 +1,12 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package main
+
+import "syscall"
+
+// processAlive reports whether a process with the given PID is running
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	// EPERM means it exists but belongs to someone else
+	return err == nil || err == syscall.EPERM
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// lockSuffix is added to a save path to name its lock file
+const lockSuffix = ".lock"
+
+// saveLocks are the save files this process holds the lock for, by absolute path
+var saveLocks = struct {
+	sync.Mutex
+	held map[string]bool
+}{held: make(map[string]bool)}
+
+// writeFileAtomic replaces filename with data so that a crash or a full disk
+// leaves either the old file or the new one, never a truncated mix. The data is
+// written to a temporary file in the same directory, synced and renamed over
+// the original. An existing file keeps its permissions, perm is only used for a
+// new one.
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	if info, err := os.Stat(filename); err == nil {
+		perm = info.Mode().Perm()
+	}
+
+	dir := filepath.Dir(filename)
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(filename)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+
+	// Clean up the temporary file on any failure below
+	committed := false
+	defer func() {
+		if !committed {
+			tmp.Close()
+			os.Remove(tmpName)
+		}
+	}()
+
+	if _, err := tmp.Write(data); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmpName, perm); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpName, filename); err != nil {
+		return err
+	}
+	committed = true
+
+	// Sync the directory so the rename itself survives a crash. Not every
+	// platform can, so failures here are ignored.
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// lockSaveFile makes sure this process holds the lock file next to filename, so
+// that no other tracker writes the same save. A lock left behind by a process
+// that is no longer running is taken over.
+func lockSaveFile(filename string) error {
+	path, err := filepath.Abs(filename)
+	if err != nil {
+		return err
+	}
+
+	saveLocks.Lock()
+	defer saveLocks.Unlock()
+	if saveLocks.held[path] {
+		return nil
+	}
+
+	hostname, _ := os.Hostname()
+	lockPath := path + lockSuffix
+	for attempt := 0; attempt < 2; attempt++ {
+		file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			fmt.Fprintf(file, "%d\n%s\n", os.Getpid(), hostname)
+			file.Close()
+			saveLocks.held[path] = true
+			return nil
+		}
+		if !os.IsExist(err) {
+			return fmt.Errorf("error creating lock file: %v", err)
+		}
+
+		pid, owner := readSaveLock(lockPath)
+		if owner != hostname || pid <= 0 {
+			return fmt.Errorf("%s is locked by a tracker on %s, delete %s if that tracker is gone", filename, owner, lockPath)
+		}
+		if pid == os.Getpid() {
+			saveLocks.held[path] = true
+			return nil
+		}
+		if processAlive(pid) {
+			return fmt.Errorf("%s is in use by another tracker (process %d)", filename, pid)
+		}
+
+		// The owner is gone, take the stale lock over
+		os.Remove(lockPath)
+	}
+	return fmt.Errorf("could not lock %s", filename)
+}
+
+// readSaveLock reads the PID and host name from a lock file
+func readSaveLock(lockPath string) (int, string) {
+	data, err := os.ReadFile(lockPath)
+	if err != nil {
+		return 0, ""
+	}
+	lines := strings.SplitN(string(data), "\n", 3)
+	pid, _ := strconv.Atoi(strings.TrimSpace(lines[0]))
+	hostname := ""
+	if len(lines) > 1 {
+		hostname = strings.TrimSpace(lines[1])
+	}
+	return pid, hostname
+}
+
+// releaseSaveLocks removes every lock file this process holds
+func releaseSaveLocks() {
+	saveLocks.Lock()
+	defer saveLocks.Unlock()
+
+	for path := range saveLocks.held {
+		if pid, _ := readSaveLock(path + lockSuffix); pid == os.Getpid() {
+			os.Remove(path + lockSuffix)
+		}
+		delete(saveLocks.held, path)
+	}
+}
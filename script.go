@@ -0,0 +1,329 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// diffContext is how many unchanged lines are shown around each change in a state diff
+const diffContext = 2
+
+// maxScriptDepth is how deeply scripts may run other scripts
+const maxScriptDepth = 8
+
+// run executes other commands, so it's added here to avoid an initialization cycle
+func init() {
+	commands["run"] = cmdRun
+}
+
+// ScriptError is a failed line of a command script
+type ScriptError struct {
+	File string
+	Line int
+	Text string
+	Err  error
+}
+
+func (e *ScriptError) Error() string {
+	return fmt.Sprintf("%s:%d: %v (%s)", e.File, e.Line, e.Err, e.Text)
+}
+
+// RunScript runs each line of a command script through ExecuteCommand. Blank lines
+// and lines starting with # are skipped. It stops at the first failing line unless
+// keepGoing is set, and returns every error it hit.
+func (ct *CombatTracker) RunScript(r io.Reader, name string, keepGoing bool) []error {
+	var errs []error
+
+	scanner := bufio.NewScanner(r)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if err := ct.ExecuteCommand(line); err != nil {
+			errs = append(errs, &ScriptError{File: name, Line: lineNo, Text: line, Err: err})
+			if !keepGoing {
+				return errs
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, fmt.Errorf("%s: error reading script: %v", name, err))
+	}
+	return errs
+}
+
+// RunScriptFile runs the command script in filename, "-" meaning stdin. A script
+// that runs itself, directly or through others, is refused.
+func (ct *CombatTracker) RunScriptFile(filename string, keepGoing bool) []error {
+	path, err := filepath.Abs(filename)
+	if err != nil || filename == "-" {
+		path = filename
+	}
+	for _, running := range ct.scripts {
+		if running == path {
+			return []error{fmt.Errorf("%s runs itself", filename)}
+		}
+	}
+	if len(ct.scripts) >= maxScriptDepth {
+		return []error{fmt.Errorf("scripts nested more than %d deep at %s", maxScriptDepth, filename)}
+	}
+	ct.scripts = append(ct.scripts, path)
+	defer func() { ct.scripts = ct.scripts[:len(ct.scripts)-1] }()
+
+	if filename == "-" {
+		return ct.RunScript(os.Stdin, "stdin", keepGoing)
+	}
+
+	file, err := os.Open(filename)
+	if err != nil {
+		return []error{fmt.Errorf("error opening script: %v", err)}
+	}
+	defer file.Close()
+	return ct.RunScript(file, filename, keepGoing)
+}
+
+// StartRecording appends every command that runs successfully to filename, so the
+// session can be replayed later with run
+func (ct *CombatTracker) StartRecording(filename string) error {
+	ct.StopRecording()
+
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("error opening recording: %v", err)
+	}
+	ct.recorder = file
+	ct.printf("Recording commands to %s\n", filename)
+	return nil
+}
+
+// StopRecording closes the current recording, if any
+func (ct *CombatTracker) StopRecording() {
+	if ct.recorder == nil {
+		return
+	}
+	ct.recorder.Close()
+	ct.recorder = nil
+	ct.println("Recording stopped.")
+}
+
+// recordCommand writes a successful command line to the recording. The dice it
+// rolled go on a rolls line before it, so a replay rolls the same.
+func (ct *CombatTracker) recordCommand(line string, rolls []int) {
+	if ct.recorder == nil {
+		return
+	}
+	if len(rolls) > 0 {
+		results := make([]string, len(rolls))
+		for i, r := range rolls {
+			results[i] = strconv.Itoa(r)
+		}
+		fmt.Fprintf(ct.recorder, "rolls %s\n", strings.Join(results, " "))
+	}
+	fmt.Fprintln(ct.recorder, line)
+}
+
+// captureRolls notes every die rolled until the returned function is called,
+// which returns the results. Results queued by a rolls line are used first. A
+// queued result too big for the die it's used for means the rolls line doesn't
+// belong to the command; the die is rolled instead and the function returns an
+// error.
+func (ct *CombatTracker) captureRolls() func() ([]int, error) {
+	roll := rollDie
+	queued := ct.queuedRolls
+	ct.queuedRolls = nil
+
+	var results []int
+	var mismatch error
+	rollDie = func(sides int) int {
+		var r int
+		if len(queued) > 0 {
+			r, queued = queued[0], queued[1:]
+			if r > sides {
+				if mismatch == nil {
+					mismatch = fmt.Errorf("recorded roll %d can't come from a d%d, the rolls line before this command doesn't match it", r, sides)
+				}
+				r = roll(sides)
+			}
+		} else {
+			r = roll(sides)
+		}
+		results = append(results, r)
+		return r
+	}
+	return func() ([]int, error) {
+		rollDie = roll
+		return results, mismatch
+	}
+}
+
+// stateLines renders the combat state as indented JSON lines for diffing. The
+// player tokens are left out because they are secrets.
+func (ct *CombatTracker) stateLines() []string {
+	snapshot := *ct
+	snapshot.PlayerTokens = nil
+	data, err := json.MarshalIndent(&snapshot, "", "  ")
+	if err != nil {
+		return []string{fmt.Sprintf("error creating JSON: %v", err)}
+	}
+	return strings.Split(string(data), "\n")
+}
+
+// diffLines compares two sets of lines and returns the changes with a little
+// context, in the style of a unified diff. It returns nil when they are equal.
+func diffLines(before, after []string) []string {
+	// Longest common subsequence table, filled from the end
+	lcs := make([][]int, len(before)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(after)+1)
+	}
+	for i := len(before) - 1; i >= 0; i-- {
+		for j := len(after) - 1; j >= 0; j-- {
+			if before[i] == after[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	// Walk the table to produce every line with its marker
+	var all []string
+	changed := false
+	i, j := 0, 0
+	for i < len(before) || j < len(after) {
+		switch {
+		case i < len(before) && j < len(after) && before[i] == after[j]:
+			all = append(all, "  "+before[i])
+			i++
+			j++
+		case i < len(before) && (j == len(after) || lcs[i+1][j] >= lcs[i][j+1]):
+			all = append(all, "- "+before[i])
+			changed = true
+			i++
+		default:
+			all = append(all, "+ "+after[j])
+			changed = true
+			j++
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	// Keep only the changes and the lines around them
+	keep := make([]bool, len(all))
+	for n, line := range all {
+		if line[0] == ' ' {
+			continue
+		}
+		for k := n - diffContext; k <= n+diffContext; k++ {
+			if k >= 0 && k < len(all) {
+				keep[k] = true
+			}
+		}
+	}
+
+	var diff []string
+	for n, line := range all {
+		if !keep[n] {
+			continue
+		}
+		if n == 0 || !keep[n-1] {
+			diff = append(diff, "@@")
+		}
+		diff = append(diff, line)
+	}
+	return diff
+}
+
+// runRunSubcommand runs one or more command scripts against the save file
+func runRunSubcommand(ct *CombatTracker, args []string, opts *subcommandOptions) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: run [-diff] [-k] <script...>")
+	}
+
+	before := ct.stateLines()
+
+	var errs []error
+	for _, filename := range args {
+		errs = append(errs, ct.RunScriptFile(filename, opts.keepGoing)...)
+		if len(errs) > 0 && !opts.keepGoing {
+			break
+		}
+	}
+
+	if opts.diff {
+		diff := diffLines(before, ct.stateLines())
+		if diff == nil {
+			fmt.Fprintln(opts.stdout, "No changes to the combat state.")
+		}
+		for _, line := range diff {
+			fmt.Fprintln(opts.stdout, line)
+		}
+	}
+
+	if len(errs) == 0 {
+		return nil
+	}
+	messages := make([]string, len(errs))
+	for i, err := range errs {
+		messages[i] = err.Error()
+	}
+	return fmt.Errorf("%s", strings.Join(messages, "\n"))
+}
+
+func cmdRun(ct *CombatTracker, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: run <script>")
+	}
+
+	errs := ct.RunScriptFile(args[0], false)
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+func cmdRolls(ct *CombatTracker, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: rolls <result...>")
+	}
+
+	rolls := make([]int, len(args))
+	for i, arg := range args {
+		r, err := strconv.Atoi(arg)
+		if err != nil || r < 1 {
+			return fmt.Errorf("invalid die result %q", arg)
+		}
+		rolls[i] = r
+	}
+	ct.queuedRolls = rolls
+	return nil
+}
+
+func cmdRecord(ct *CombatTracker, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: record <file> | record off")
+	}
+
+	if strings.ToLower(args[0]) == "off" {
+		if ct.recorder == nil {
+			return fmt.Errorf("not recording")
+		}
+		ct.StopRecording()
+		return nil
+	}
+	return ct.StartRecording(args[0])
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestScripts replays every script in testdata/scripts and compares the output
+// and the final state with the .out and .state golden files next to it. Run
+// go test -update after an intended change to rewrite them.
+func TestScripts(t *testing.T) {
+	scripts, err := filepath.Glob(filepath.Join("testdata", "scripts", "*.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scripts) == 0 {
+		t.Fatal("no scripts in testdata/scripts")
+	}
+
+	for _, script := range scripts {
+		name := strings.TrimSuffix(filepath.Base(script), ".txt")
+		t.Run(name, func(t *testing.T) {
+			ct, out := newTestTracker(t)
+			for _, err := range ct.RunScriptFile(script, true) {
+				fmt.Fprintf(out, "error: %v\n", err)
+			}
+
+			base := strings.TrimSuffix(script, ".txt")
+			checkGolden(t, base+".out", out.String())
+			checkGolden(t, base+".state", strings.Join(ct.stateLines(), "\n")+"\n")
+		})
+	}
+}
+
+// TestRecordReplay records a session with one set of dice and replays it with
+// another, which must end in the same state
+func TestRecordReplay(t *testing.T) {
+	recording := filepath.Join(t.TempDir(), "session.txt")
+
+	ct, _ := newTestTracker(t)
+	useTestDice(t, 42)
+	for _, line := range []string{
+		"record " + recording,
+		"add Goblin d20+2 2d6+1",
+		"dup goblin 3",
+		"add Thorin 14 30 pc",
+		"start",
+		"dmg goblin,goblin2 2d6+3 fire",
+		"heal thorin 1d8",
+		"next",
+		"record off",
+	} {
+		if err := ct.ExecuteCommand(line); err != nil {
+			t.Fatalf("%s: %v", line, err)
+		}
+	}
+
+	data, err := os.ReadFile(recording)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "rolls ") {
+		t.Fatalf("recording has no rolls lines:\n%s", data)
+	}
+
+	replayed, _ := newTestTracker(t)
+	useTestDice(t, 7)
+	if errs := replayed.RunScriptFile(recording, false); len(errs) > 0 {
+		t.Fatalf("replay failed: %v", errs)
+	}
+
+	if diff := diffLines(ct.stateLines(), replayed.stateLines()); diff != nil {
+		t.Errorf("replay differs from the recorded session:\n%s", strings.Join(diff, "\n"))
+	}
+}
+
+func TestRunRefusesRecursion(t *testing.T) {
+	dir := t.TempDir()
+	self := filepath.Join(dir, "self.txt")
+	if err := os.WriteFile(self, []byte("add Orc 10 15\nrun "+self+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ct, _ := newTestTracker(t)
+	errs := ct.RunScriptFile(self, false)
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "runs itself") {
+		t.Fatalf("got %v, want a script that runs itself to fail", errs)
+	}
+	if len(ct.Combatants) != 1 {
+		t.Errorf("got %d combatants, want the script to run once", len(ct.Combatants))
+	}
+}
+
+func TestRunLimitsNesting(t *testing.T) {
+	// Each script runs the next, one more than the limit allows
+	dir := t.TempDir()
+	for i := 0; i <= maxScriptDepth; i++ {
+		script := fmt.Sprintf("add Orc%d 10 15\nrun %s\n", i, filepath.Join(dir, fmt.Sprintf("%d.txt", i+1)))
+		if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("%d.txt", i)), []byte(script), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ct, _ := newTestTracker(t)
+	errs := ct.RunScriptFile(filepath.Join(dir, "0.txt"), false)
+	if len(errs) != 1 || !strings.Contains(errs[0].Error(), "nested more than") {
+		t.Fatalf("got %v, want the nesting limit to stop the scripts", errs)
+	}
+	if len(ct.Combatants) != maxScriptDepth {
+		t.Errorf("got %d combatants, want %d scripts to run", len(ct.Combatants), maxScriptDepth)
+	}
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// playerPage is the initiative page served at /, it has no external assets
+//
+//go:embed player.html
+var playerPage []byte
+
+// eventKeepAlive is how often an idle event stream gets a comment, so proxies and
+// phones going to sleep notice a dropped connection
+const eventKeepAlive = 15 * time.Second
+
+// CombatantView is a combatant as players see it. HP is left out for monsters
+// unless the server was started with monster HP shown.
+type CombatantView struct {
+	Name          string   `json:"name"`
+	Initiative    int      `json:"initiative"`
+	IsPlayer      bool     `json:"isPlayer"`
+	IsConscious   bool     `json:"isConscious"`
+	IsCurrent     bool     `json:"isCurrent"`
+	Group         string   `json:"group,omitempty"`
+	Health        string   `json:"health"`
+	StatusEffects []string `json:"statusEffects"`
+	CurrentHP     *int     `json:"currentHP,omitempty"`
+	MaxHP         *int     `json:"maxHP,omitempty"`
+	TemporaryHP   *int     `json:"temporaryHP,omitempty"`
+}
+
+// RoundView is the round and whose turn it is
+type RoundView struct {
+	Round       int    `json:"round"`
+	IsActive    bool   `json:"isActive"`
+	CurrentTurn string `json:"currentTurn,omitempty"`
+}
+
+// EncounterView is the whole encounter as players see it
+type EncounterView struct {
+	CampaignName  string `json:"campaignName"`
+	EncounterName string `json:"encounterName"`
+	RoundView
+	Combatants []CombatantView `json:"combatants"`
+}
+
+// PlayerView builds the player-facing view of the encounter. Hidden combatants
+// are left out and aliases replace real names. It copies everything it needs, so
+// the view stays valid while the tracker carries on.
+func (ct *CombatTracker) PlayerView(showMonsterHP bool) EncounterView {
+	view := EncounterView{
+		CampaignName:  ct.CampaignName,
+		EncounterName: ct.EncounterName,
+		RoundView:     RoundView{Round: ct.Round, IsActive: ct.IsActive, CurrentTurn: ct.playerTurnName()},
+		Combatants:    make([]CombatantView, 0, len(ct.Combatants)),
+	}
+
+	for i := range ct.Combatants {
+		if ct.Combatants[i].Hidden {
+			continue
+		}
+		view.Combatants = append(view.Combatants, ct.combatantView(i, showMonsterHP))
+	}
+	return view
+}
+
+// combatantView is one combatant as players see it
+func (ct *CombatTracker) combatantView(index int, showMonsterHP bool) CombatantView {
+	c := ct.Combatants[index]
+	hasTurn := ct.IsActive && ct.CurrentTurnIdx >= 0 && ct.CurrentTurnIdx < len(ct.Combatants)
+
+	cv := CombatantView{
+		Name:          c.DisplayName(),
+		Initiative:    c.Initiative,
+		IsPlayer:      c.IsPlayer,
+		IsConscious:   c.IsConscious,
+		IsCurrent:     hasTurn && (index == ct.CurrentTurnIdx || ct.inSameGroup(index, ct.CurrentTurnIdx)),
+		Group:         ct.playerGroupName(index),
+		Health:        HealthState(c.CurrentHP, c.MaxHP),
+		StatusEffects: c.effectLabels(),
+	}
+	if c.IsPlayer || showMonsterHP {
+		currentHP, maxHP, tempHP := c.CurrentHP, c.MaxHP, c.TemporaryHP
+		cv.CurrentHP, cv.MaxHP, cv.TemporaryHP = &currentHP, &maxHP, &tempHP
+	}
+	return cv
+}
+
+// playerLog copies the combat log for players. Entries that give away monster
+// numbers are left out unless those are shown, and hidden combatants and aliases
+// are kept secret.
+func (ct *CombatTracker) playerLog(showMonsterHP bool) []LogEntry {
+	redact := ct.playerRedactor()
+	entries := make([]LogEntry, 0, len(ct.Log))
+	for _, entry := range ct.Log {
+		if entry.Secret && !showMonsterHP {
+			continue
+		}
+		message, ok := redact(entry.Message)
+		if !ok {
+			continue
+		}
+		entry.Message = message
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+// Server serves a read-only view of the encounter over HTTP: JSON endpoints, a
+// player page and a stream of updates. It never reads the live tracker: Publish
+// stores a copy after each command and requests are answered from that copy.
+type Server struct {
+	showMonsterHP bool
+
+	mu          sync.RWMutex
+	view        EncounterView
+	viewJSON    []byte
+	log         []LogEntry
+	subscribers map[chan []byte]bool
+
+	session *Session // Where player updates go, nil while updates are off
+}
+
+// NewServer creates a server, optionally showing monster HP to players
+func NewServer(showMonsterHP bool) *Server {
+	return &Server{
+		showMonsterHP: showMonsterHP,
+		view:          EncounterView{Combatants: []CombatantView{}},
+		viewJSON:      []byte(`{"combatants":[]}`),
+		log:           []LogEntry{},
+		subscribers:   make(map[chan []byte]bool),
+	}
+}
+
+// Publish stores the current state of the tracker for the next requests and
+// pushes it to the event streams when the player view changed
+func (s *Server) Publish(ct *CombatTracker) {
+	view := ct.PlayerView(s.showMonsterHP)
+	log := ct.playerLog(s.showMonsterHP)
+	viewJSON, err := json.Marshal(view)
+	if err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.view = view
+	s.log = log
+	if bytes.Equal(viewJSON, s.viewJSON) {
+		return
+	}
+	s.viewJSON = viewJSON
+
+	for events := range s.subscribers {
+		// A slow reader only needs the latest view, so replace anything unsent
+		select {
+		case <-events:
+		default:
+		}
+		events <- viewJSON
+	}
+}
+
+// subscribe registers a new event stream, primed with the current view
+func (s *Server) subscribe() chan []byte {
+	events := make(chan []byte, 1)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscribers[events] = true
+	events <- s.viewJSON
+	return events
+}
+
+func (s *Server) unsubscribe(events chan []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.subscribers, events)
+}
+
+// serveEvents streams the player view as Server-Sent Events, one event per change
+func (s *Server) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+
+	events := s.subscribe()
+	defer s.unsubscribe(events)
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case data := <-events:
+			fmt.Fprintf(w, "data: %s\n\n", data)
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+// Handler returns the HTTP handler for the API
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /{$}", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(playerPage)
+	})
+	mux.HandleFunc("GET /events", s.serveEvents)
+	s.registerPlayerAPI(mux)
+	mux.HandleFunc("GET /api/encounter", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		writeJSON(w, s.view)
+	})
+	mux.HandleFunc("GET /api/order", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		writeJSON(w, s.view.Combatants)
+	})
+	mux.HandleFunc("GET /api/round", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		writeJSON(w, s.view.RoundView)
+	})
+	mux.HandleFunc("GET /api/log", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+		writeJSON(w, s.log)
+	})
+	return mux
+}
+
+// Start listens on addr and serves requests in the background. Errors binding the
+// address are returned straight away.
+func (s *Server) Start(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("error starting web server: %v", err)
+	}
+
+	go http.Serve(listener, s.Handler())
+	fmt.Printf("Serving the encounter at http://%s/\n", listener.Addr())
+	return nil
+}
+
+// writeJSON writes v as the JSON response. Any page may read it, so players can
+// build their own displays.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	writeJSONStatus(w, http.StatusOK, v)
+}
+
+// writeJSONStatus writes v as a JSON response with the given status code
+func writeJSONStatus(w http.ResponseWriter, status int, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("error creating JSON: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.WriteHeader(status)
+	w.Write(append(data, '\n'))
+}
@@ -0,0 +1,123 @@
+package main
+
+import "sync"
+
+// Session is the tracker shared by every frontend: the prompt, the full-screen
+// interface and the web server. The tracker itself has no locking, so frontends
+// only touch it through the session, one command at a time. Goroutines that just
+// need to look use Snapshot.
+type Session struct {
+	mu sync.Mutex
+	ct *CombatTracker
+}
+
+// NewSession wraps a tracker for shared use. The tracker should not be used
+// directly from then on.
+func NewSession(ct *CombatTracker) *Session {
+	return &Session{ct: ct}
+}
+
+// Lock holds the session across several steps, such as a menu item prompting for
+// input. Prefer Do where the work fits in a function.
+func (s *Session) Lock() {
+	s.mu.Lock()
+}
+
+// Unlock releases the session after Lock
+func (s *Session) Unlock() {
+	s.mu.Unlock()
+}
+
+// Tracker returns the live tracker. Only use it while holding the session.
+func (s *Session) Tracker() *CombatTracker {
+	return s.ct
+}
+
+// Do runs fn with the session held and then tells listeners about the change
+func (s *Session) Do(fn func(ct *CombatTracker)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	fn(s.ct)
+	s.ct.changed()
+}
+
+// Execute runs a line of the command language, like ExecuteCommand
+func (s *Session) Execute(line string) error {
+	var err error
+	s.Do(func(ct *CombatTracker) {
+		err = ct.ExecuteCommand(line)
+	})
+	return err
+}
+
+// Snapshot returns a copy of the tracker that shares nothing with the live one,
+// so it stays consistent however long the caller keeps it
+func (s *Session) Snapshot() *CombatTracker {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.ct.Clone()
+}
+
+// Clone makes a deep copy of the combat state. The copy keeps the save path but
+// has no output, recording or listener of its own.
+func (ct *CombatTracker) Clone() *CombatTracker {
+	clone := *ct
+	clone.out = nil
+	clone.recorder = nil
+	clone.onChange = nil
+	clone.unbacked = nil
+
+	clone.Combatants = make([]Combatant, len(ct.Combatants))
+	for i, c := range ct.Combatants {
+		c.StatusEffects = append([]string{}, c.StatusEffects...)
+		c.Loot = append([]string(nil), c.Loot...)
+		if c.EffectDurations != nil {
+			durations := make(map[string]int, len(c.EffectDurations))
+			for effect, rounds := range c.EffectDurations {
+				durations[effect] = rounds
+			}
+			c.EffectDurations = durations
+		}
+		clone.Combatants[i] = c
+	}
+
+	clone.StatusEffects = append([]string{}, ct.StatusEffects...)
+	clone.Log = append([]LogEntry(nil), ct.Log...)
+	clone.PendingUpdates = append([]PlayerUpdate(nil), ct.PendingUpdates...)
+	if ct.Stats != nil {
+		stats := *ct.Stats
+		stats.Combatants = make([]CombatantStats, len(ct.Stats.Combatants))
+		for i, s := range ct.Stats.Combatants {
+			s.Conditions = append([]string{}, s.Conditions...)
+			stats.Combatants[i] = s
+		}
+		stats.TurnTimes = append([]TurnTime(nil), ct.Stats.TurnTimes...)
+		clone.Stats = &stats
+	}
+	if ct.CollapsedGroups != nil {
+		clone.CollapsedGroups = make(map[string]bool, len(ct.CollapsedGroups))
+		for group, collapsed := range ct.CollapsedGroups {
+			clone.CollapsedGroups[group] = collapsed
+		}
+	}
+	if ct.PlayerTokens != nil {
+		clone.PlayerTokens = make(map[string]string, len(ct.PlayerTokens))
+		for token, name := range ct.PlayerTokens {
+			clone.PlayerTokens[token] = name
+		}
+	}
+	return &clone
+}
+
+// replaceWith takes over the combat state of a loaded tracker in place, so every
+// frontend holding this tracker sees it. Output, recording, listeners and saves
+// waiting for a backup stay.
+func (ct *CombatTracker) replaceWith(loaded *CombatTracker) {
+	loaded.out = ct.out
+	loaded.unbacked = ct.unbacked
+	loaded.recorder = ct.recorder
+	loaded.onChange = ct.onChange
+	loaded.revision = ct.revision
+	*ct = *loaded
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Run with go test -race: the web server and the engine share the tracker only
+// through the session, so the race detector should find nothing here.
+func TestPlayerAPIAlongsideCommands(t *testing.T) {
+	session, ts := newTestServer(t)
+	session.Execute("start")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				kind, body := "damage", `{"amount": 1}`
+				if j%2 == 1 {
+					kind = "heal"
+				}
+				req, err := http.NewRequest("POST", ts.URL+"/api/me/"+kind, strings.NewReader(body))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				req.Header.Set("Authorization", "Bearer thorin-token")
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				resp.Body.Close()
+			}
+		}()
+	}
+	for _, path := range []string{"/api/me", "/api/order", "/api/round", "/api/log", "/api/encounter"} {
+		wg.Add(1)
+		go func(path string) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				req, err := http.NewRequest("GET", ts.URL+path, nil)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				req.Header.Set("Authorization", "Bearer thorin-token")
+				resp, err := http.DefaultClient.Do(req)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				resp.Body.Close()
+			}
+		}(path)
+	}
+
+	for i := 0; i < 20; i++ {
+		if err := session.Execute("next"); err != nil {
+			t.Fatal(err)
+		}
+		session.Do(func(ct *CombatTracker) { ct.AdjustHP(1, -1) })
+		session.Snapshot()
+	}
+	wg.Wait()
+}
+
+func TestMenuPromptReleasesSession(t *testing.T) {
+	session, _ := newTestServer(t)
+	read, write, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer read.Close()
+	defer write.Close()
+
+	// The menu holds the session while it runs a handler, as the main loop does
+	session.Lock()
+	handled := make(chan struct{})
+	go func() {
+		handleAdjustHP(session.Tracker(), sessionInput{Input: NewInput(read), session: session})
+		session.Unlock()
+		close(handled)
+	}()
+
+	// A player update arrives while the GM is still typing
+	done := make(chan struct{})
+	go func() {
+		session.Do(func(ct *CombatTracker) { ct.AdjustHP(0, -5) })
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		write.Close()
+		t.Fatal("the menu prompt held the session while waiting for input")
+	}
+
+	if _, err := write.WriteString("1\n-3\n"); err != nil {
+		t.Fatal(err)
+	}
+	<-handled
+
+	if hp := session.Snapshot().Combatants[0].CurrentHP; hp != 22 {
+		t.Errorf("Thorin has %d HP, want 22", hp)
+	}
+}
@@ -0,0 +1,317 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CombatStats are the running statistics of the current or last combat
+type CombatStats struct {
+	Started    string           `json:"started"`
+	Ended      string           `json:"ended,omitempty"`
+	Rounds     int              `json:"rounds"`
+	Turns      int              `json:"turns"`
+	Combatants []CombatantStats `json:"combatants"`
+	TurnTimes  []TurnTime       `json:"turnTimes,omitempty"` // Every finished turn, in order
+}
+
+// CombatantStats are one combatant's statistics for a combat. Damage and
+// healing are credited to whoever has the turn when they happen, except that
+// attacks credit the attacker.
+type CombatantStats struct {
+	Name        string   `json:"name"`
+	IsPlayer    bool     `json:"isPlayer"`
+	DamageDealt int      `json:"damageDealt"`
+	DamageTaken int      `json:"damageTaken"`
+	HealingDone int      `json:"healingDone"`
+	Turns       int      `json:"turns"`
+	Downs       int      `json:"downs"`       // Times dropped to 0 HP
+	Conditions  []string `json:"conditions"`  // Every status effect suffered, once each
+	TurnSeconds int      `json:"turnSeconds"` // Time spent on finished turns
+}
+
+// startStats begins collecting statistics for a new combat
+func (ct *CombatTracker) startStats() {
+	ct.Stats = &CombatStats{
+		Started:    clockNow().Format(time.RFC3339),
+		Rounds:     ct.Round,
+		Combatants: []CombatantStats{},
+	}
+	ct.noteTurn()
+}
+
+// combatantStats returns the statistics for the combatant at index, adding
+// them on first use. Nil outside combat.
+func (ct *CombatTracker) combatantStats(index int) *CombatantStats {
+	if !ct.IsActive || ct.Stats == nil || index < 0 || index >= len(ct.Combatants) {
+		return nil
+	}
+
+	c := &ct.Combatants[index]
+	for i := range ct.Stats.Combatants {
+		if ct.Stats.Combatants[i].Name == c.Name {
+			return &ct.Stats.Combatants[i]
+		}
+	}
+	ct.Stats.Combatants = append(ct.Stats.Combatants, CombatantStats{Name: c.Name, IsPlayer: c.IsPlayer, Conditions: []string{}})
+	return &ct.Stats.Combatants[len(ct.Stats.Combatants)-1]
+}
+
+// noteTurn counts the turn that just started for everyone taking it
+func (ct *CombatTracker) noteTurn() {
+	if ct.Stats == nil {
+		return
+	}
+	ct.Stats.Turns++
+	ct.Stats.Rounds = ct.Round
+	for _, m := range ct.groupMembers(ct.CurrentTurnIdx) {
+		if s := ct.combatantStats(m); s != nil {
+			s.Turns++
+		}
+	}
+}
+
+// actingIndex is who damage and healing are credited to: the attacker during
+// an attack, otherwise whoever has the turn
+func (ct *CombatTracker) actingIndex() int {
+	if ct.actor != nil {
+		return *ct.actor
+	}
+	return ct.CurrentTurnIdx
+}
+
+// noteDamage counts damage the combatant at index took, and whether it went down
+func (ct *CombatTracker) noteDamage(index, damage int, downed bool) {
+	if s := ct.combatantStats(index); s != nil {
+		s.DamageTaken += damage
+		if downed {
+			s.Downs++
+		}
+	}
+	if s := ct.combatantStats(ct.actingIndex()); s != nil {
+		s.DamageDealt += damage
+	}
+}
+
+// noteHealing credits healing to whoever is acting
+func (ct *CombatTracker) noteHealing(healed int) {
+	if s := ct.combatantStats(ct.actingIndex()); s != nil {
+		s.HealingDone += healed
+	}
+}
+
+// noteCondition records a status effect the combatant at index suffered
+func (ct *CombatTracker) noteCondition(index int, effect string) {
+	s := ct.combatantStats(index)
+	if s == nil || contains(s.Conditions, effect) {
+		return
+	}
+	s.Conditions = append(s.Conditions, effect)
+}
+
+// statsRows returns the statistics of everyone in combat order, including
+// combatants nothing happened to
+func (ct *CombatTracker) statsRows() []CombatantStats {
+	rows := []CombatantStats{}
+	seen := make(map[string]bool)
+	for _, c := range ct.Combatants {
+		row := CombatantStats{Name: c.Name, IsPlayer: c.IsPlayer, Conditions: []string{}}
+		for _, s := range ct.Stats.Combatants {
+			if s.Name == c.Name {
+				row = s
+			}
+		}
+		seen[c.Name] = true
+		rows = append(rows, row)
+	}
+
+	// Keep anyone who has since left the encounter
+	for _, s := range ct.Stats.Combatants {
+		if !seen[s.Name] {
+			rows = append(rows, s)
+		}
+	}
+	return rows
+}
+
+// elapsed is how long the combat has run, up to its end if it has ended
+func (s *CombatStats) elapsed() time.Duration {
+	started, err := time.Parse(time.RFC3339, s.Started)
+	if err != nil {
+		return 0
+	}
+	ended := clockNow()
+	if s.Ended != "" {
+		if t, err := time.Parse(time.RFC3339, s.Ended); err == nil {
+			ended = t
+		}
+	}
+	return ended.Sub(started).Round(time.Second)
+}
+
+// averageTurn is the mean real time per turn
+func (s *CombatStats) averageTurn() time.Duration {
+	if s.Turns == 0 {
+		return 0
+	}
+	return (s.elapsed() / time.Duration(s.Turns)).Round(time.Second)
+}
+
+// ShowStats prints the statistics of the current or last combat
+func (ct *CombatTracker) ShowStats() {
+	if ct.Stats == nil {
+		ct.println("No combat statistics yet, they're collected from the start of combat.")
+		return
+	}
+
+	ct.println("\n===== COMBAT STATISTICS =====")
+	ct.printf("Rounds: %d | Turns: %d | Time: %s | Average turn: %s\n",
+		ct.Stats.Rounds, ct.Stats.Turns, ct.Stats.elapsed(), ct.Stats.averageTurn())
+	ct.println("-------------------")
+	ct.printf("    %-20s %6s %6s %7s %5s %5s %6s  %s\n", "Name", "Dealt", "Taken", "Healed", "Turns", "Downs", "Time", "Conditions")
+	for _, s := range ct.statsRows() {
+		playerMarker := "M"
+		if s.IsPlayer {
+			playerMarker = "P"
+		}
+		ct.printf("  %s %-20s %6d %6d %7d %5d %5d %6s  %s\n", playerMarker, s.Name,
+			s.DamageDealt, s.DamageTaken, s.HealingDone, s.Turns, s.Downs,
+			formatClock(time.Duration(s.TurnSeconds)*time.Second), strings.Join(s.Conditions, ", "))
+	}
+	ct.println("-------------------")
+}
+
+// exportStatsRows are the statistics an export includes. Like the order exports,
+// they leave hidden combatants out and use aliases unless they're for the GM.
+func (ct *CombatTracker) exportStatsRows(gm bool) []CombatantStats {
+	rows := ct.statsRows()
+	if gm {
+		return rows
+	}
+
+	exported := make([]CombatantStats, 0, len(rows))
+	for _, row := range rows {
+		if i := ct.indexByName(row.Name); i >= 0 {
+			c := &ct.Combatants[i]
+			if c.Hidden {
+				continue
+			}
+			row.Name = c.DisplayName()
+		}
+		exported = append(exported, row)
+	}
+	return exported
+}
+
+// ExportStatsMarkdown writes the combat statistics as a Markdown report
+func (ct *CombatTracker) ExportStatsMarkdown(w io.Writer, gm bool) error {
+	if ct.Stats == nil {
+		return fmt.Errorf("no combat statistics yet")
+	}
+
+	fmt.Fprintf(w, "# %s: %s\n\n", ct.CampaignName, ct.EncounterName)
+	fmt.Fprintf(w, "- Rounds: %d\n", ct.Stats.Rounds)
+	fmt.Fprintf(w, "- Turns: %d\n", ct.Stats.Turns)
+	fmt.Fprintf(w, "- Time: %s\n", ct.Stats.elapsed())
+	fmt.Fprintf(w, "- Average turn: %s\n\n", ct.Stats.averageTurn())
+	fmt.Fprintln(w, "| Name | Type | Damage Dealt | Damage Taken | Healing Done | Turns | Downs | Turn Time | Conditions |")
+	fmt.Fprintln(w, "|------|------|--------------|--------------|--------------|-------|-------|-----------|------------|")
+	for _, s := range ct.exportStatsRows(gm) {
+		kind := "Monster"
+		if s.IsPlayer {
+			kind = "Player"
+		}
+		_, err := fmt.Fprintf(w, "| %s | %s | %d | %d | %d | %d | %d | %s | %s |\n",
+			strings.ReplaceAll(s.Name, "|", `\|`), kind, s.DamageDealt, s.DamageTaken, s.HealingDone, s.Turns, s.Downs,
+			formatClock(time.Duration(s.TurnSeconds)*time.Second), strings.Join(s.Conditions, ", "))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExportStatsCSV writes the combat statistics as CSV, one row per combatant
+func (ct *CombatTracker) ExportStatsCSV(w io.Writer, gm bool) error {
+	if ct.Stats == nil {
+		return fmt.Errorf("no combat statistics yet")
+	}
+
+	out := csv.NewWriter(w)
+	out.Write([]string{"name", "type", "damageDealt", "damageTaken", "healingDone", "turns", "downs", "turnSeconds", "conditions"})
+	for _, s := range ct.exportStatsRows(gm) {
+		kind := "monster"
+		if s.IsPlayer {
+			kind = "player"
+		}
+		out.Write([]string{
+			s.Name,
+			kind,
+			strconv.Itoa(s.DamageDealt),
+			strconv.Itoa(s.DamageTaken),
+			strconv.Itoa(s.HealingDone),
+			strconv.Itoa(s.Turns),
+			strconv.Itoa(s.Downs),
+			strconv.Itoa(s.TurnSeconds),
+			strings.Join(s.Conditions, ";"),
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// exportStats writes the statistics as md or csv to a file, or stdout when
+// filename is empty. Only the GM's copy includes hidden combatants and real names.
+func (ct *CombatTracker) exportStats(format, filename string, stdout io.Writer, gm bool) error {
+	w := stdout
+	if filename != "" {
+		file, err := os.Create(filename)
+		if err != nil {
+			return fmt.Errorf("error creating %s: %v", filename, err)
+		}
+		defer file.Close()
+		w = file
+	}
+
+	var err error
+	switch format {
+	case "md", "markdown":
+		err = ct.ExportStatsMarkdown(w, gm)
+	case "csv":
+		err = ct.ExportStatsCSV(w, gm)
+	default:
+		return fmt.Errorf("unknown format %q, use md or csv", format)
+	}
+	if err != nil {
+		return err
+	}
+	if filename != "" {
+		ct.printf("Wrote combat statistics to %s\n", filename)
+	}
+	return nil
+}
+
+func cmdStats(ct *CombatTracker, args []string) error {
+	if len(args) == 0 {
+		ct.ShowStats()
+		return nil
+	}
+	gm := strings.ToLower(args[len(args)-1]) == "gm"
+	if gm {
+		args = args[:len(args)-1]
+	}
+	if len(args) == 0 || len(args) > 2 {
+		return fmt.Errorf("usage: stats [md|csv [file] [gm]]")
+	}
+
+	filename := ""
+	if len(args) == 2 {
+		filename = args[1]
+	}
+	return ct.exportStats(strings.ToLower(args[0]), filename, ct.output(), gm)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ResolveTargets turns a target selector into combatant indices. A selector is a
+// comma separated list of combatant numbers ("1,3"), ranges ("2-5"), numbered
+// names ("goblin1-3"), name globs ("gob*") and the keywords "all", "monsters"
+// and "players" ("all monsters" and "all players" work too). Indices are
+// returned in initiative order without duplicates.
+func (ct *CombatTracker) ResolveTargets(selector string) ([]int, error) {
+	selected := make(map[int]bool)
+
+	for _, part := range strings.Split(selector, ",") {
+		part = strings.ToLower(strings.TrimSpace(part))
+		if part == "" {
+			continue
+		}
+
+		switch part {
+		case "all", "everyone":
+			for i := range ct.Combatants {
+				selected[i] = true
+			}
+			continue
+		case "monsters", "all monsters":
+			for i, c := range ct.Combatants {
+				if !c.IsPlayer {
+					selected[i] = true
+				}
+			}
+			continue
+		case "players", "all players":
+			for i, c := range ct.Combatants {
+				if c.IsPlayer {
+					selected[i] = true
+				}
+			}
+			continue
+		}
+
+		// Single combatant number
+		if n, err := strconv.Atoi(part); err == nil {
+			if n < 1 || n > len(ct.Combatants) {
+				return nil, fmt.Errorf("no combatant number %d", n)
+			}
+			selected[n-1] = true
+			continue
+		}
+
+		// Range of combatant numbers
+		if from, to, ok := strings.Cut(part, "-"); ok {
+			first, errFirst := strconv.Atoi(strings.TrimSpace(from))
+			last, errLast := strconv.Atoi(strings.TrimSpace(to))
+			if errFirst == nil && errLast == nil {
+				if first > last {
+					first, last = last, first
+				}
+				if first < 1 || last > len(ct.Combatants) {
+					return nil, fmt.Errorf("range %s is outside 1-%d", part, len(ct.Combatants))
+				}
+				for n := first; n <= last; n++ {
+					selected[n-1] = true
+				}
+				continue
+			}
+		}
+
+		// Range of numbered names, where the unnumbered name counts as the first
+		if name, first, last, ok := nameRange(part); ok && ct.indexByLowerName(part) < 0 {
+			for n := first; n <= last; n++ {
+				index := ct.indexByLowerName(fmt.Sprintf("%s%d", name, n))
+				if index < 0 && n == 1 {
+					index = ct.indexByLowerName(name)
+				}
+				if index < 0 {
+					return nil, fmt.Errorf("no combatant named %s%d", name, n)
+				}
+				selected[index] = true
+			}
+			continue
+		}
+
+		// Name glob, matched without regard to case
+		if _, err := path.Match(part, ""); err != nil {
+			return nil, fmt.Errorf("invalid name pattern %q", part)
+		}
+		matched := false
+		for i, c := range ct.Combatants {
+			if ok, _ := path.Match(part, strings.ToLower(c.Name)); ok {
+				selected[i] = true
+				matched = true
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("no combatant matches %q", part)
+		}
+	}
+
+	if len(selected) == 0 {
+		return nil, fmt.Errorf("no targets selected")
+	}
+
+	indices := make([]int, 0, len(selected))
+	for i := range selected {
+		indices = append(indices, i)
+	}
+	sort.Ints(indices)
+	return indices, nil
+}
+
+// nameRangePattern matches a numbered name range such as "goblin1-3"
+var nameRangePattern = regexp.MustCompile(`^(.*\D)(\d+)-(\d+)$`)
+
+// nameRange splits a range of numbered names like "goblin1-3" into the name and
+// the first and last numbers
+func nameRange(s string) (name string, first, last int, ok bool) {
+	m := nameRangePattern.FindStringSubmatch(s)
+	if m == nil {
+		return "", 0, 0, false
+	}
+	first, _ = strconv.Atoi(m[2])
+	last, _ = strconv.Atoi(m[3])
+	if first > last {
+		first, last = last, first
+	}
+	return strings.TrimSpace(m[1]), first, last, true
+}
+
+// indexByLowerName finds a combatant by name, ignoring case, or returns -1
+func (ct *CombatTracker) indexByLowerName(name string) int {
+	for i, c := range ct.Combatants {
+		if strings.EqualFold(c.Name, name) {
+			return i
+		}
+	}
+	return -1
+}
+
+// RollSave rolls a d20 saving throw with the given bonus against dc
+func RollSave(bonus, dc int) (int, bool) {
+	roll := rollDie(20) + bonus
+	return roll, roll >= dc
+}
+
+// AdjustHPMultiple applies the same HP change to several combatants through AdjustHPTyped.
+// Targets marked in halved (for example those that made their save) get half the
+// change, rounded down.
+func (ct *CombatTracker) AdjustHPMultiple(indices []int, amount int, halved map[int]bool, damageType string) {
+	for _, index := range indices {
+		if index < 0 || index >= len(ct.Combatants) {
+			ct.println("Invalid combatant index!")
+			return
+		}
+	}
+
+	for _, index := range indices {
+		change := amount
+		if halved[index] {
+			change = amount / 2
+			// Typed damage already logs what was taken
+			if damageType == "" {
+				ct.printf("%s takes half: ", ct.Combatants[index].Name)
+			}
+		}
+		ct.AdjustHPTyped(index, change, damageType)
+	}
+}
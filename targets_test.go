@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestNameRangeTargets(t *testing.T) {
+	tests := []struct {
+		names  []string
+		target string
+		want   string // Names selected, or the error
+	}{
+		{[]string{"Thorin", "Goblin", "Goblin2", "Goblin3"}, "goblin1-3", "Goblin, Goblin2, Goblin3"},
+		{[]string{"Thorin", "Goblin", "Goblin2", "Goblin3"}, "goblin2-3", "Goblin2, Goblin3"},
+		{[]string{"Goblin1", "Goblin2", "Goblin3", "Goblin"}, "goblin1-2", "Goblin1, Goblin2"},
+		{[]string{"Thorin", "Goblin", "Goblin2"}, "goblin1-3", "no combatant named goblin3"},
+		{[]string{"Thorin", "Goblin", "Goblin2"}, "thorin,goblin1-2", "Thorin, Goblin, Goblin2"},
+		{[]string{"Thorin", "Goblin", "Goblin2"}, "2-3", "Goblin, Goblin2"},
+		{[]string{"Thorin", "Unit7-9"}, "unit7-9", "Unit7-9"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			ct, _ := newTestTracker(t)
+			for _, name := range tt.names {
+				ct.Combatants = append(ct.Combatants, Combatant{Name: name, StatusEffects: []string{}})
+			}
+
+			indices, err := ct.resolveTargetList(tt.target)
+			got := ""
+			if err != nil {
+				got = err.Error()
+			} else {
+				names := make([]string, len(indices))
+				for i, index := range indices {
+					names[i] = ct.Combatants[index].Name
+				}
+				got = strings.Join(names, ", ")
+			}
+			if got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestArmorClassForNameRange(t *testing.T) {
+	ct, _ := newTestTracker(t)
+	if err := ct.ExecuteCommand("add Goblin 12 7"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ct.ExecuteCommand("dup goblin 2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ct.ExecuteCommand("ac goblin1-3 15"); err != nil {
+		t.Fatal(err)
+	}
+	if len(ct.Combatants) != 3 {
+		t.Fatalf("got %d goblins, want 3", len(ct.Combatants))
+	}
+	for _, c := range ct.Combatants {
+		if c.AC != 15 {
+			t.Errorf("%s has AC %d, want 15", c.Name, c.AC)
+		}
+	}
+}
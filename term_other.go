@@ -0,0 +1,37 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+
+package main
+
+import (
+	"errors"
+	"os"
+)
+
+// terminalState is unused on platforms without raw mode support
+type terminalState struct{}
+
+var errNoRawMode = errors.New("raw terminal mode is not supported on this platform")
+
+// isTerminal always reports false, so the tracker falls back to plain line input
+func isTerminal(fd uintptr) bool {
+	return false
+}
+
+func makeRaw(fd uintptr) (*terminalState, error) {
+	return nil, errNoRawMode
+}
+
+func makeRawPolling(fd uintptr) (*terminalState, error) {
+	return nil, errNoRawMode
+}
+
+func restoreTerminal(fd uintptr, state *terminalState) error {
+	return errNoRawMode
+}
+
+func terminalSize(fd uintptr) (int, int, error) {
+	return 0, 0, errNoRawMode
+}
+
+// notifyResize does nothing, there is no resize signal on this platform
+func notifyResize(ch chan<- os.Signal) {}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// clockNow tells the time for turn timers and statistics, replaced when a deterministic clock is needed
+var clockNow = time.Now
+
+// TurnTime is one finished turn in the combat statistics
+type TurnTime struct {
+	Round   int    `json:"round"`
+	Name    string `json:"name"`
+	Started string `json:"started"`
+	Ended   string `json:"ended"`
+	Seconds int    `json:"seconds"`
+}
+
+// parseClockTime reads a time saved by the timers, zero when there is none
+func parseClockTime(s string) time.Time {
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// formatClock shows a duration as minutes and seconds, e.g. 1:05
+func formatClock(d time.Duration) string {
+	seconds := int(d.Round(time.Second) / time.Second)
+	if seconds < 0 {
+		seconds = 0
+	}
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+// parseTimeLimit reads a limit such as "90", "90s" or "1m30s"
+func parseTimeLimit(s string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(s); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < time.Second {
+		return 0, fmt.Errorf("invalid time limit %q, use seconds or a duration like 1m30s", s)
+	}
+	return d.Round(time.Second), nil
+}
+
+// startTurnTimer starts timing the turn that just began, and the round when it's the first turn
+func (ct *CombatTracker) startTurnTimer() {
+	now := clockNow().Format(time.RFC3339)
+	ct.TurnStarted = now
+	if ct.CurrentTurnIdx == 0 {
+		ct.RoundStarted = now
+	}
+}
+
+// endTurnTimer stops timing the current turn. The time is added to everyone
+// taking the turn, and a warning is printed when the turn ran over its limit.
+// This is the only printed warning: while the turn runs, turnTimer flags it.
+func (ct *CombatTracker) endTurnTimer() {
+	started := parseClockTime(ct.TurnStarted)
+	ct.TurnStarted = ""
+	if started.IsZero() || ct.CurrentTurnIdx < 0 || ct.CurrentTurnIdx >= len(ct.Combatants) {
+		return
+	}
+
+	now := clockNow()
+	elapsed := now.Sub(started).Round(time.Second)
+	if elapsed < 0 {
+		elapsed = 0
+	}
+	seconds := int(elapsed / time.Second)
+
+	for _, m := range ct.groupMembers(ct.CurrentTurnIdx) {
+		ct.Combatants[m].TimeSpent += seconds
+		if s := ct.combatantStats(m); s != nil {
+			s.TurnSeconds += seconds
+		}
+	}
+	if ct.Stats != nil {
+		ct.Stats.TurnTimes = append(ct.Stats.TurnTimes, TurnTime{
+			Round:   ct.Round,
+			Name:    ct.turnName(ct.CurrentTurnIdx),
+			Started: started.Format(time.RFC3339),
+			Ended:   now.Format(time.RFC3339),
+			Seconds: seconds,
+		})
+	}
+
+	if limit := ct.turnLimit(ct.CurrentTurnIdx); limit > 0 && elapsed > limit {
+		ct.printf("%s's turn took %s, over the %s limit.\n", ct.turnName(ct.CurrentTurnIdx), formatClock(elapsed), formatClock(limit))
+	}
+}
+
+// endRoundTimer reports how long the round that just finished took
+func (ct *CombatTracker) endRoundTimer() {
+	started := parseClockTime(ct.RoundStarted)
+	if started.IsZero() {
+		return
+	}
+	ct.printf("Round %d took %s.\n", ct.Round, formatClock(clockNow().Sub(started)))
+}
+
+// turnLimit is the soft time limit for the turn starting at index: the
+// player's own budget when set, otherwise the tracker's limit
+func (ct *CombatTracker) turnLimit(index int) time.Duration {
+	limit := ct.TurnLimit
+	for _, m := range ct.groupMembers(index) {
+		if budget := ct.Combatants[m].TurnBudget; budget > 0 {
+			limit = budget
+		}
+	}
+	return time.Duration(limit) * time.Second
+}
+
+// turnTimer describes the running turn timer, e.g. "Turn 0:42 / 1:30". It is
+// empty outside combat.
+func (ct *CombatTracker) turnTimer() string {
+	started := parseClockTime(ct.TurnStarted)
+	if !ct.IsActive || started.IsZero() || ct.CurrentTurnIdx < 0 || ct.CurrentTurnIdx >= len(ct.Combatants) {
+		return ""
+	}
+
+	elapsed := clockNow().Sub(started)
+	timer := "Turn " + formatClock(elapsed)
+	if limit := ct.turnLimit(ct.CurrentTurnIdx); limit > 0 {
+		timer += " / " + formatClock(limit)
+		if elapsed > limit {
+			timer += " (over the limit!)"
+		}
+	}
+	if roundStarted := parseClockTime(ct.RoundStarted); !roundStarted.IsZero() {
+		timer += " | Round " + formatClock(clockNow().Sub(roundStarted))
+	}
+	return timer
+}
+
+// SetTurnLimit sets the soft time limit for every turn, zero for none
+func (ct *CombatTracker) SetTurnLimit(limit time.Duration) {
+	ct.TurnLimit = int(limit / time.Second)
+	if limit == 0 {
+		ct.println("Turns have no time limit.")
+	} else {
+		ct.printf("Turns should take no more than %s.\n", formatClock(limit))
+	}
+
+	// Auto-save state
+	ct.AutoSave()
+}
+
+// SetTurnBudget gives combatants their own turn time limit, zero to use the tracker's
+func (ct *CombatTracker) SetTurnBudget(indices []int, budget time.Duration) {
+	for _, index := range indices {
+		if index < 0 || index >= len(ct.Combatants) {
+			ct.println("Invalid combatant index!")
+			return
+		}
+	}
+
+	for _, index := range indices {
+		c := &ct.Combatants[index]
+		c.TurnBudget = int(budget / time.Second)
+		if budget == 0 {
+			ct.printf("%s uses the turn time limit.\n", c.Name)
+		} else {
+			ct.printf("%s has %s per turn.\n", c.Name, formatClock(budget))
+		}
+	}
+
+	// Auto-save state
+	ct.AutoSave()
+}
+
+// ResetTimeSpent clears everyone's total turn time
+func (ct *CombatTracker) ResetTimeSpent() {
+	for i := range ct.Combatants {
+		ct.Combatants[i].TimeSpent = 0
+	}
+	ct.println("Turn time totals reset.")
+
+	// Auto-save state
+	ct.AutoSave()
+}
+
+// ShowTimers prints the running timers and everyone's total turn time, players first
+func (ct *CombatTracker) ShowTimers() {
+	if timer := ct.turnTimer(); timer != "" {
+		ct.println(timer)
+	}
+	if ct.TurnLimit > 0 {
+		ct.printf("Turn limit: %s\n", formatClock(time.Duration(ct.TurnLimit)*time.Second))
+	}
+
+	order := make([]int, len(ct.Combatants))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return ct.Combatants[order[a]].IsPlayer && !ct.Combatants[order[b]].IsPlayer
+	})
+
+	ct.println("Time spent on turns:")
+	for _, i := range order {
+		c := &ct.Combatants[i]
+		budget := ""
+		if c.TurnBudget > 0 {
+			budget = fmt.Sprintf(" (%s per turn)", formatClock(time.Duration(c.TurnBudget)*time.Second))
+		}
+		ct.printf("  %-20s %s%s\n", c.Name, formatClock(time.Duration(c.TimeSpent)*time.Second), budget)
+	}
+}
+
+// isTimerOff reports whether a limit argument turns the limit off
+func isTimerOff(arg string) bool {
+	return strings.ToLower(arg) == "off" || arg == "0"
+}
+
+func cmdTimer(ct *CombatTracker, args []string) error {
+	usage := fmt.Errorf("usage: timer [limit <time>|off | budget <targets> <time>|off | reset]")
+	if len(args) == 0 {
+		ct.ShowTimers()
+		return nil
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "limit":
+		if len(args) != 2 {
+			return usage
+		}
+		var limit time.Duration
+		if !isTimerOff(args[1]) {
+			var err error
+			if limit, err = parseTimeLimit(args[1]); err != nil {
+				return err
+			}
+		}
+		ct.SetTurnLimit(limit)
+	case "budget":
+		if len(args) != 3 {
+			return usage
+		}
+		indices, err := ct.resolveTargetList(args[1])
+		if err != nil {
+			return err
+		}
+		var budget time.Duration
+		if !isTimerOff(args[2]) {
+			if budget, err = parseTimeLimit(args[2]); err != nil {
+				return err
+			}
+		}
+		ct.SetTurnBudget(indices, budget)
+	case "reset":
+		if len(args) != 1 {
+			return usage
+		}
+		ct.ResetTimeSpent()
+	default:
+		return usage
+	}
+	return nil
+}
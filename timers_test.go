@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTurnLimitWarning(t *testing.T) {
+	ct, out := newTestTracker(t)
+	clock := useTestClock(t)
+	ct.AddCombatant("Thorin", 15, 30, true)
+	ct.AddCombatant("Mira", 12, 22, true)
+	ct.AddCombatant("Goblin", 10, 7, false)
+	ct.SetTurnLimit(90 * time.Second)
+	ct.SetTurnBudget([]int{ct.indexByName("Mira")}, 2*time.Minute)
+	ct.StartCombat()
+
+	// Thorin runs over the tracker's limit
+	clock.Advance(80 * time.Second)
+	if timer := ct.turnTimer(); timer != "Turn 1:20 / 1:30 | Round 1:20" {
+		t.Errorf("timer is %q", timer)
+	}
+	clock.Advance(20 * time.Second)
+	if timer := ct.turnTimer(); timer != "Turn 1:40 / 1:30 (over the limit!) | Round 1:40" {
+		t.Errorf("timer is %q", timer)
+	}
+	out.Reset()
+	ct.NextTurn()
+	if !strings.Contains(out.String(), "Thorin's turn took 1:40, over the 1:30 limit.") {
+		t.Errorf("no warning for Thorin:\n%s", out)
+	}
+
+	// Mira's own budget is longer
+	clock.Advance(100 * time.Second)
+	out.Reset()
+	ct.NextTurn()
+	if strings.Contains(out.String(), "over the") {
+		t.Errorf("Mira was warned within the budget:\n%s", out)
+	}
+
+	clock.Advance(30 * time.Second)
+	out.Reset()
+	ct.NextTurn()
+	if !strings.Contains(out.String(), "Round 1 took 3:50.") {
+		t.Errorf("round time missing:\n%s", out)
+	}
+
+	for name, want := range map[string]int{"Thorin": 100, "Mira": 100, "Goblin": 30} {
+		if got := ct.Combatants[ct.indexByName(name)].TimeSpent; got != want {
+			t.Errorf("%s spent %d seconds, want %d", name, got, want)
+		}
+	}
+	if n := len(ct.Stats.TurnTimes); n != 3 {
+		t.Errorf("got %d turn times, want 3", n)
+	}
+}
@@ -0,0 +1,663 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxTUILines is how many lines the log pane keeps
+const maxTUILines = 500
+
+// tuiHelp is shown on the key hint line of the full-screen interface
+const tuiHelp = "↑↓ select  n next  d damage  h heal  t temp  c condition  i init  : command  q quit"
+
+// tuiOutput collects engine messages for the log pane
+type tuiOutput struct {
+	lines   []string
+	partial string
+}
+
+func (o *tuiOutput) Write(p []byte) (int, error) {
+	text := o.partial + string(p)
+	parts := strings.Split(text, "\n")
+	o.partial = parts[len(parts)-1]
+	for _, line := range parts[:len(parts)-1] {
+		o.add(line)
+	}
+	return len(p), nil
+}
+
+// add appends a line to the log pane, skipping blank lines
+func (o *tuiOutput) add(line string) {
+	line = strings.TrimRight(line, "\r")
+	if strings.TrimSpace(line) == "" {
+		return
+	}
+	o.lines = append(o.lines, line)
+	if len(o.lines) > maxTUILines {
+		o.lines = o.lines[len(o.lines)-maxTUILines:]
+	}
+}
+
+// tuiRow is one row of the initiative list: a combatant, or a whole collapsed group
+type tuiRow struct {
+	index     int
+	collapsed bool
+}
+
+// tui is the state of the full-screen interface
+type tui struct {
+	ct     *CombatTracker
+	editor *LineEditor // Command history and completion for the command bar
+	out    *tuiOutput
+
+	width, height int
+	selected      string // Name of the selected combatant, kept across re-sorts
+
+	editing    bool
+	command    []rune
+	cursor     int
+	historyIdx int
+	quit       bool
+	timer      string // Turn timer in the title when last drawn
+}
+
+// RunTUI runs the full-screen interface on the terminal until the user quits.
+// Commands typed into the command bar go through ExecuteCommand, so they behave
+// exactly as they do at the normal prompt. The session is held except while
+// waiting for keys.
+func RunTUI(session *Session, editor *LineEditor) error {
+	fd := os.Stdin.Fd()
+	if !isTerminal(fd) {
+		return fmt.Errorf("the full-screen interface needs a terminal")
+	}
+	if editor == nil {
+		editor = &LineEditor{out: io.Discard}
+	}
+
+	state, err := makeRawPolling(fd)
+	if err != nil {
+		return err
+	}
+	defer restoreTerminal(fd, state)
+
+	session.Lock()
+	defer session.Unlock()
+
+	ct := session.Tracker()
+	t := &tui{ct: ct, editor: editor, out: &tuiOutput{}}
+	for _, entry := range ct.Log {
+		t.out.add(entry.Message)
+	}
+	if ct.IsActive && ct.CurrentTurnIdx >= 0 && ct.CurrentTurnIdx < len(ct.Combatants) {
+		t.selected = ct.Combatants[ct.CurrentTurnIdx].Name
+	}
+
+	previous := ct.out
+	ct.SetOutput(t.out)
+	defer ct.SetOutput(previous)
+
+	resize := make(chan os.Signal, 1)
+	notifyResize(resize)
+	defer signal.Stop(resize)
+
+	// Switch to the alternate screen so the normal scrollback is left alone
+	fmt.Print("\x1b[?1049h")
+	defer fmt.Print("\x1b[?25h\x1b[?1049l")
+
+	t.updateSize()
+	t.draw()
+
+	buf := make([]byte, 64)
+	var pending []byte
+	for !t.quit {
+		select {
+		case <-resize:
+			t.updateSize()
+			t.draw()
+		default:
+		}
+
+		// Reads time out after a moment, so resizes and changes made elsewhere,
+		// such as player updates, are noticed without a key press
+		seen := ct.revision
+		session.Unlock()
+		n, err := stdin.Read(buf)
+		session.Lock()
+		if err != nil && err != io.EOF {
+			return err
+		}
+		if n == 0 {
+			// The turn timer ticks even when nothing else changes
+			if ct.revision != seen || ct.turnTimer() != t.timer {
+				t.draw()
+			}
+			continue
+		}
+
+		pending = append(pending, buf[:n]...)
+		for len(pending) > 0 && !t.quit {
+			key, size := splitKey(pending)
+			if size == 0 {
+				break
+			}
+			pending = pending[size:]
+			t.handleKey(key)
+		}
+		t.ct.changed()
+		t.draw()
+	}
+
+	// Keys typed after quitting belong to the prompt
+	stdin.Unread(pending)
+	return nil
+}
+
+// updateSize reads the terminal size, falling back to 80x24
+func (t *tui) updateSize() {
+	width, height, err := terminalSize(os.Stdout.Fd())
+	if err != nil || width < 20 || height < 8 {
+		width, height = 80, 24
+	}
+	t.width, t.height = width, height
+}
+
+// rows returns the initiative list rows, with collapsed groups shown once
+func (t *tui) rows() []tuiRow {
+	ct := t.ct
+	rows := []tuiRow{}
+	for i, c := range ct.Combatants {
+		members := ct.groupMembers(i)
+		if len(members) > 1 && ct.CollapsedGroups[c.Group] {
+			if members[0] == i {
+				rows = append(rows, tuiRow{index: i, collapsed: true})
+			}
+			continue
+		}
+		rows = append(rows, tuiRow{index: i})
+	}
+	return rows
+}
+
+// selectedRow returns the position of the selected combatant in rows
+func (t *tui) selectedRow(rows []tuiRow) int {
+	for r, row := range rows {
+		for _, m := range t.rowMembers(row) {
+			if t.ct.Combatants[m].Name == t.selected {
+				return r
+			}
+		}
+	}
+	return 0
+}
+
+// rowMembers returns the combatants a row stands for
+func (t *tui) rowMembers(row tuiRow) []int {
+	if row.collapsed {
+		return t.ct.groupMembers(row.index)
+	}
+	return []int{row.index}
+}
+
+// selectedIndex returns the index of the selected combatant, or -1 when there are none
+func (t *tui) selectedIndex() int {
+	rows := t.rows()
+	if len(rows) == 0 {
+		return -1
+	}
+	return rows[t.selectedRow(rows)].index
+}
+
+// moveSelection moves the selection by delta rows, staying within the list
+func (t *tui) moveSelection(delta int) {
+	rows := t.rows()
+	if len(rows) == 0 {
+		return
+	}
+	r := t.selectedRow(rows) + delta
+	if r < 0 {
+		r = 0
+	}
+	if r >= len(rows) {
+		r = len(rows) - 1
+	}
+	t.selected = t.ct.Combatants[rows[r].index].Name
+}
+
+// targetWord returns the selected combatant's name as a command argument
+func (t *tui) targetWord() string {
+	index := t.selectedIndex()
+	if index < 0 {
+		return ""
+	}
+	name := t.ct.Combatants[index].Name
+	if strings.Contains(name, " ") {
+		name = `"` + name + `"`
+	}
+	return name
+}
+
+// startCommand opens the command bar with text already filled in
+func (t *tui) startCommand(text string) {
+	t.editing = true
+	t.command = []rune(text)
+	t.cursor = len(t.command)
+	t.historyIdx = len(t.editor.history)
+}
+
+// handleKey reacts to one key press
+func (t *tui) handleKey(key string) {
+	if t.editing {
+		t.handleCommandKey(key)
+		return
+	}
+
+	switch key {
+	case "q", "\x03", "\x04":
+		t.quit = true
+	case "\x1b[A", "\x1bOA", "k":
+		t.moveSelection(-1)
+	case "\x1b[B", "\x1bOB", "j":
+		t.moveSelection(1)
+	case "\x1b[H", "\x1bOH", "\x1b[1~", "g":
+		t.moveSelection(-len(t.ct.Combatants))
+	case "\x1b[F", "\x1bOF", "\x1b[4~", "G":
+		t.moveSelection(len(t.ct.Combatants))
+	case "\x1b[5~":
+		t.moveSelection(-(t.height / 2))
+	case "\x1b[6~":
+		t.moveSelection(t.height / 2)
+	case "n":
+		t.run("next")
+	case "d":
+		t.startCommand("dmg " + t.targetWord() + " ")
+	case "h":
+		t.startCommand("heal " + t.targetWord() + " ")
+	case "t":
+		t.startCommand("temp " + t.targetWord() + " ")
+	case "c":
+		t.startCommand("cond " + t.targetWord() + " +")
+	case "i":
+		t.startCommand("init " + t.targetWord() + " ")
+	case "\x0c":
+		fmt.Print("\x1b[2J")
+	case ":", "/", "\r", "\n":
+		t.startCommand("")
+	}
+}
+
+// handleCommandKey edits the command bar
+func (t *tui) handleCommandKey(key string) {
+	switch key {
+	case "\r", "\n":
+		line := strings.TrimSpace(string(t.command))
+		t.editing = false
+		t.editor.addHistory(line)
+		t.run(line)
+
+	case "\x1b", "\x03":
+		t.editing = false
+
+	case "\x7f", "\x08":
+		if t.cursor > 0 {
+			t.command = append(t.command[:t.cursor-1], t.command[t.cursor:]...)
+			t.cursor--
+		} else if len(t.command) == 0 {
+			t.editing = false
+		}
+
+	case "\x1b[3~":
+		if t.cursor < len(t.command) {
+			t.command = append(t.command[:t.cursor], t.command[t.cursor+1:]...)
+		}
+
+	case "\x1b[D", "\x1bOD", "\x02":
+		if t.cursor > 0 {
+			t.cursor--
+		}
+
+	case "\x1b[C", "\x1bOC", "\x06":
+		if t.cursor < len(t.command) {
+			t.cursor++
+		}
+
+	case "\x1b[H", "\x1bOH", "\x1b[1~", "\x01":
+		t.cursor = 0
+
+	case "\x1b[F", "\x1bOF", "\x1b[4~", "\x05":
+		t.cursor = len(t.command)
+
+	case "\x1b[A", "\x1bOA":
+		if t.historyIdx > 0 {
+			t.historyIdx--
+			t.command = []rune(t.editor.history[t.historyIdx])
+			t.cursor = len(t.command)
+		}
+
+	case "\x1b[B", "\x1bOB":
+		if t.historyIdx < len(t.editor.history) {
+			t.historyIdx++
+			t.command = []rune{}
+			if t.historyIdx < len(t.editor.history) {
+				t.command = []rune(t.editor.history[t.historyIdx])
+			}
+			t.cursor = len(t.command)
+		}
+
+	case "\x15":
+		t.command = t.command[t.cursor:]
+		t.cursor = 0
+
+	case "\t":
+		var options []string
+		t.command, t.cursor, options = t.editor.complete(t.command, t.cursor)
+		if len(options) > 0 {
+			t.out.add(strings.Join(options, "  "))
+		}
+
+	default:
+		r, _ := utf8.DecodeRuneInString(key)
+		if len(key) > utf8.RuneLen(r) || r < 0x20 {
+			return
+		}
+		t.command = append(t.command[:t.cursor], append([]rune{r}, t.command[t.cursor:]...)...)
+		t.cursor++
+	}
+}
+
+// run executes a command line and shows its output in the log pane
+func (t *tui) run(line string) {
+	if line == "" {
+		return
+	}
+
+	switch strings.ToLower(line) {
+	case "quit", "exit", "q":
+		t.quit = true
+		return
+	case "tui":
+		return
+	}
+
+	t.out.add("> " + line)
+	if err := t.ct.ExecuteCommand(line); err != nil {
+		t.out.add(err.Error())
+	}
+
+	// Follow the turn when it moves on
+	if strings.ToLower(line) == "next" || strings.ToLower(line) == "start" {
+		if t.ct.IsActive && t.ct.CurrentTurnIdx < len(t.ct.Combatants) {
+			t.selected = t.ct.Combatants[t.ct.CurrentTurnIdx].Name
+		}
+	}
+}
+
+// fitWidth truncates or pads s to exactly width characters on screen. Color
+// escape sequences take up no room and are kept whole, so truncating colored
+// text never cuts through one or drops the reset at its end.
+func fitWidth(s string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+	visible := visibleWidth(s)
+	if visible <= width {
+		return s + strings.Repeat(" ", width-visible)
+	}
+
+	keep := width
+	if width > 1 {
+		keep = width - 1
+	}
+	var sb strings.Builder
+	n := 0
+	for i := 0; i < len(s); {
+		if size := escapeLength(s[i:]); size > 0 {
+			sb.WriteString(s[i : i+size])
+			i += size
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		if n < keep {
+			sb.WriteString(s[i : i+size])
+			n++
+			if n == keep && width > 1 {
+				sb.WriteString("…")
+			}
+		}
+		i += size
+	}
+	return sb.String()
+}
+
+// visibleWidth counts the characters of s that show on screen, leaving out
+// escape sequences
+func visibleWidth(s string) int {
+	width := 0
+	for i := 0; i < len(s); {
+		if size := escapeLength(s[i:]); size > 0 {
+			i += size
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(s[i:])
+		width++
+		i += size
+	}
+	return width
+}
+
+// escapeLength returns the length of the CSI escape sequence s starts with, or 0
+func escapeLength(s string) int {
+	if len(s) < 2 || s[0] != 0x1b || s[1] != '[' {
+		return 0
+	}
+	for i := 2; i < len(s); i++ {
+		if s[i] >= 0x40 && s[i] <= 0x7e {
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+// listLines renders the initiative list pane
+func (t *tui) listLines(width, height int) []string {
+	ct := t.ct
+	rows := t.rows()
+	selected := t.selectedRow(rows)
+	colors := palette{enabled: colorAllowed()}
+
+	// Scroll so the selection stays in view
+	first := 0
+	if selected >= height {
+		first = selected - height + 1
+	}
+
+	lines := []string{}
+	for r := first; r < len(rows) && len(lines) < height; r++ {
+		row := rows[r]
+		c := ct.Combatants[row.index]
+		members := ct.groupMembers(row.index)
+
+		marker := " "
+		if ct.IsActive && ct.CurrentTurnIdx >= 0 && ct.CurrentTurnIdx < len(ct.Combatants) &&
+			(row.index == ct.CurrentTurnIdx || ct.inSameGroup(row.index, ct.CurrentTurnIdx)) {
+			marker = "→"
+		}
+
+		kind := "M"
+		if c.IsPlayer {
+			kind = "P"
+		}
+
+		name := c.Name
+		currentHP, maxHP := c.CurrentHP, c.MaxHP
+		if row.collapsed {
+			kind = "G"
+			name = fmt.Sprintf("%s x%d", c.Group, len(members))
+			currentHP, maxHP = 0, 0
+			for _, m := range members {
+				currentHP += ct.Combatants[m].CurrentHP
+				maxHP += ct.Combatants[m].MaxHP
+			}
+		} else if len(members) > 1 {
+			name = "· " + name
+		}
+		if !row.collapsed && c.Hidden {
+			name += " (hidden)"
+		}
+
+		hp := fmt.Sprintf("%d/%d", currentHP, maxHP)
+		if !row.collapsed && !c.IsConscious {
+			hp = "down " + hp
+		}
+		if !row.collapsed && len(c.StatusEffects) > 0 {
+			hp = "*" + hp
+		}
+
+		text := fmt.Sprintf("%s %s %2d ", marker, kind, c.Initiative)
+		nameWidth := width - utf8.RuneCountInString(text) - utf8.RuneCountInString(hp) - 1
+		if nameWidth < 1 {
+			lines = append(lines, fitWidth(text+hp, width))
+			continue
+		}
+
+		// The selected row is always shown in reverse video, other rows in color
+		var line string
+		if r == selected {
+			line = "\x1b[" + sgrReverse + "m" + text + fitWidth(name, nameWidth) + " " + hp + "\x1b[0m"
+		} else {
+			nameStr := colors.name(fitWidth(name, nameWidth), c.IsPlayer)
+			if marker != " " {
+				nameStr = colors.wrap(sgrBold, fitWidth(name, nameWidth))
+			}
+			line = text + nameStr + " " + colors.health(hp, HealthState(currentHP, maxHP))
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// detailLines renders the pane describing the selected combatant
+func (t *tui) detailLines() []string {
+	ct := t.ct
+	index := t.selectedIndex()
+	if index < 0 {
+		return []string{"No combatants yet.", "", "Press : and type help to get started,", `e.g. add Thorin 18 40 pc`}
+	}
+
+	c := ct.Combatants[index]
+	kind := "Monster"
+	if c.IsPlayer {
+		kind = "Player"
+	}
+
+	lines := []string{
+		fmt.Sprintf("%s (%s)", c.Name, kind),
+		fmt.Sprintf("Initiative: %d", c.Initiative),
+	}
+
+	hp := fmt.Sprintf("HP: %d/%d", c.CurrentHP, c.MaxHP)
+	if c.TemporaryHP > 0 {
+		hp += fmt.Sprintf("  Temp: %d", c.TemporaryHP)
+	}
+	if !c.IsConscious {
+		hp += "  (Unconscious)"
+	}
+	lines = append(lines, hp)
+
+	if c.Alias != "" {
+		lines = append(lines, "Players see: "+c.Alias)
+	}
+	if c.Hidden {
+		lines = append(lines, "Hidden from players")
+	}
+
+	if len(c.StatusEffects) > 0 {
+		lines = append(lines, "Effects:"+palette{enabled: colorAllowed()}.badges(c.effectLabels()))
+	} else {
+		lines = append(lines, "Effects: none")
+	}
+
+	if members := ct.groupMembers(index); len(members) > 1 {
+		lines = append(lines, fmt.Sprintf("Group: %s (%d members)", c.Group, len(members)))
+		for _, m := range members {
+			member := ct.Combatants[m]
+			lines = append(lines, fmt.Sprintf("  %-16s %d/%d", member.Name, member.CurrentHP, member.MaxHP))
+		}
+	}
+	return lines
+}
+
+// draw redraws the whole screen
+func (t *tui) draw() {
+	ct := t.ct
+	width, height := t.width, t.height
+
+	listWidth := width * 45 / 100
+	if listWidth < 30 {
+		listWidth = width / 2
+	}
+	sideWidth := width - listWidth - 3
+	paneHeight := height - 3
+
+	detail := t.detailLines()
+	detailHeight := len(detail)
+	if detailHeight > paneHeight/2 {
+		detailHeight = paneHeight / 2
+	}
+
+	list := t.listLines(listWidth, paneHeight)
+
+	// The log pane shows the newest messages that fit under the details
+	logHeight := paneHeight - detailHeight - 1
+	messages := t.out.lines
+	if len(messages) > logHeight {
+		messages = messages[len(messages)-logHeight:]
+	}
+
+	var sb strings.Builder
+	sb.WriteString("\x1b[?25l\x1b[H")
+
+	title := fmt.Sprintf(" D&D COMBAT TRACKER | %s | %s | Round %d", ct.CampaignName, ct.EncounterName, ct.Round)
+	if !ct.IsActive {
+		title += " (not started)"
+	}
+	t.timer = ct.turnTimer()
+	if t.timer != "" {
+		title += " | " + t.timer
+	}
+	sb.WriteString("\x1b[7m" + fitWidth(title, width) + "\x1b[0m\r\n")
+
+	for row := 0; row < paneHeight; row++ {
+		left := strings.Repeat(" ", listWidth)
+		if row < len(list) {
+			left = list[row]
+		}
+
+		right := ""
+		switch {
+		case row < detailHeight:
+			right = detail[row]
+		case row == detailHeight:
+			right = strings.Repeat("─", sideWidth)
+		case row-detailHeight-1 < len(messages):
+			right = messages[row-detailHeight-1]
+		}
+
+		sb.WriteString(left + " │ " + fitWidth(right, sideWidth) + "\r\n")
+	}
+
+	sb.WriteString("\x1b[2m" + fitWidth(tuiHelp, width) + "\x1b[0m\r\n")
+
+	if t.editing {
+		sb.WriteString(fitWidth(":"+string(t.command), width-1))
+		fmt.Fprintf(&sb, "\x1b[%d;%dH\x1b[?25h", height, t.cursor+2)
+	} else {
+		sb.WriteString(fitWidth("Press : to type a command", width-1))
+	}
+
+	fmt.Print(sb.String())
+}
@@ -0,0 +1,26 @@
+package main
+
+import "testing"
+
+func TestFitWidth(t *testing.T) {
+	tests := []struct {
+		s     string
+		width int
+		want  string
+	}{
+		{"Goblin", 8, "Goblin  "},
+		{"Goblin", 4, "Gob…"},
+		{"\x1b[31mGoblin\x1b[0m", 8, "\x1b[31mGoblin\x1b[0m  "},
+		{"\x1b[31mGoblin\x1b[0m", 4, "\x1b[31mGob…\x1b[0m"},
+		{"HP \x1b[1;32m12/12\x1b[0m [Prone]", 6, "HP \x1b[1;32m12…\x1b[0m"},
+		{"Goblin", 0, ""},
+	}
+	for _, tt := range tests {
+		if got := fitWidth(tt.s, tt.width); got != tt.want {
+			t.Errorf("fitWidth(%q, %d) = %q, want %q", tt.s, tt.width, got, tt.want)
+		}
+		if got := visibleWidth(fitWidth(tt.s, tt.width)); got != tt.width {
+			t.Errorf("fitWidth(%q, %d) is %d wide", tt.s, tt.width, got)
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// SaveProblem is something wrong with a loaded combat state
+type SaveProblem struct {
+	Path     string // Where in the save file, e.g. combatTracker.combatants[2].currentHP
+	Message  string // What was wrong, and how it was repaired
+	Repaired bool   // False when the problem is only reported
+}
+
+func (p SaveProblem) String() string {
+	if p.Repaired {
+		return fmt.Sprintf("%s: %s", p.Path, p.Message)
+	}
+	return fmt.Sprintf("%s: %s (not repaired)", p.Path, p.Message)
+}
+
+// ValidateAndRepair checks the combat state for values the tracker can't work
+// with, such as HP out of range or a turn pointing past the last combatant.
+// Problems with an obvious fix are repaired in place. Every problem found is
+// returned, repaired or not.
+func (ct *CombatTracker) ValidateAndRepair() []SaveProblem {
+	var problems []SaveProblem
+	repaired := func(path, format string, a ...interface{}) {
+		problems = append(problems, SaveProblem{Path: "combatTracker." + path, Message: fmt.Sprintf(format, a...), Repaired: true})
+	}
+	reported := func(path, format string, a ...interface{}) {
+		problems = append(problems, SaveProblem{Path: "combatTracker." + path, Message: fmt.Sprintf(format, a...)})
+	}
+
+	names := make(map[string]int)
+	groups := make(map[string]bool)
+	for i := range ct.Combatants {
+		c := &ct.Combatants[i]
+		path := fmt.Sprintf("combatants[%d]", i)
+
+		if c.Name == "" {
+			c.Name = fmt.Sprintf("Combatant %d", i+1)
+			repaired(path+".name", "name is empty, named it %s", c.Name)
+		}
+		if first, ok := names[c.Name]; ok {
+			reported(path+".name", "%s is also the name of combatants[%d], so targeting by name finds that one", c.Name, first)
+		} else {
+			names[c.Name] = i
+		}
+		if c.Group != "" {
+			groups[c.Group] = true
+		}
+
+		if c.MaxHP < 0 {
+			repaired(path+".maxHP", "%d is negative, set to 0", c.MaxHP)
+			c.MaxHP = 0
+		}
+		if c.CurrentHP < 0 {
+			repaired(path+".currentHP", "%d is negative, set to 0", c.CurrentHP)
+			c.CurrentHP = 0
+		}
+		if c.CurrentHP > c.MaxHP {
+			repaired(path+".currentHP", "%d is more than maxHP %d, set to %d", c.CurrentHP, c.MaxHP, c.MaxHP)
+			c.CurrentHP = c.MaxHP
+		}
+		if c.TemporaryHP < 0 {
+			repaired(path+".temporaryHP", "%d is negative, set to 0", c.TemporaryHP)
+			c.TemporaryHP = 0
+		}
+
+		// Combatants added with no HP at all, like objects, stay conscious
+		if c.IsConscious && c.CurrentHP == 0 && c.MaxHP > 0 {
+			repaired(path+".isConscious", "conscious at 0 HP, marked unconscious")
+			c.IsConscious = false
+		} else if !c.IsConscious && c.CurrentHP > 0 {
+			repaired(path+".isConscious", "unconscious with %d HP, marked conscious", c.CurrentHP)
+			c.IsConscious = true
+		}
+
+		if c.CR != "" {
+			if cr, err := normalizeCR(c.CR); err != nil {
+				repaired(path+".cr", "%q isn't a challenge rating, removed it", c.CR)
+				c.CR = ""
+			} else {
+				c.CR = cr
+			}
+		}
+		if c.XP < 0 {
+			repaired(path+".xp", "%d is negative, set to 0", c.XP)
+			c.XP = 0
+		}
+		if c.Level < 0 || c.Level >= len(xpThresholds) {
+			repaired(path+".level", "%d isn't a level from 1 to %d, removed it", c.Level, len(xpThresholds)-1)
+			c.Level = 0
+		}
+		if c.AC < 0 {
+			repaired(path+".ac", "%d is negative, removed it", c.AC)
+			c.AC = 0
+		}
+		if c.TurnBudget < 0 {
+			repaired(path+".turnBudget", "%d is negative, removed it", c.TurnBudget)
+			c.TurnBudget = 0
+		}
+		if c.TimeSpent < 0 {
+			repaired(path+".timeSpent", "%d is negative, set to 0", c.TimeSpent)
+			c.TimeSpent = 0
+		}
+
+		if c.StatusEffects == nil {
+			c.StatusEffects = []string{}
+		}
+		effects := make([]string, 0, len(c.EffectDurations))
+		for effect := range c.EffectDurations {
+			effects = append(effects, effect)
+		}
+		sort.Strings(effects)
+		for _, effect := range effects {
+			rounds := c.EffectDurations[effect]
+			switch {
+			case !contains(c.StatusEffects, effect):
+				repaired(fmt.Sprintf("%s.effectDurations[%q]", path, effect), "%s isn't one of its status effects, removed the duration", effect)
+				delete(c.EffectDurations, effect)
+			case rounds <= 0:
+				repaired(fmt.Sprintf("%s.effectDurations[%q]", path, effect), "%d rounds left, made %s last until removed", rounds, effect)
+				delete(c.EffectDurations, effect)
+			}
+		}
+	}
+
+	collapsed := make([]string, 0, len(ct.CollapsedGroups))
+	for group := range ct.CollapsedGroups {
+		collapsed = append(collapsed, group)
+	}
+	sort.Strings(collapsed)
+	for _, group := range collapsed {
+		if !groups[group] {
+			repaired(fmt.Sprintf("collapsedGroups[%q]", group), "no combatant is in group %s, removed it", group)
+			delete(ct.CollapsedGroups, group)
+		}
+	}
+
+	if ct.TurnLimit < 0 {
+		repaired("turnLimit", "%d is negative, removed it", ct.TurnLimit)
+		ct.TurnLimit = 0
+	}
+
+	if ct.Round < 0 {
+		repaired("round", "%d is negative, set to 0", ct.Round)
+		ct.Round = 0
+	}
+
+	if ct.IsActive && len(ct.Combatants) == 0 {
+		repaired("isActive", "combat is active with no combatants, ended it")
+		ct.IsActive = false
+	}
+	if ct.IsActive && ct.Round == 0 {
+		repaired("round", "combat is active in round 0, set to 1")
+		ct.Round = 1
+	}
+
+	// Group members take their turn together, so they have to be next to each
+	// other in the order. Stragglers are moved up behind the rest of their group.
+	if order, moved := groupedOrder(ct.Combatants); len(moved) > 0 {
+		for _, i := range moved {
+			repaired(fmt.Sprintf("combatants[%d]", i), "%s is apart from the rest of the %s group, moved it next to them", ct.Combatants[i].Name, ct.Combatants[i].Group)
+		}
+		combatants := make([]Combatant, len(order))
+		current := ct.CurrentTurnIdx
+		for to, from := range order {
+			combatants[to] = ct.Combatants[from]
+			if from == current {
+				ct.CurrentTurnIdx = to
+			}
+		}
+		ct.Combatants = combatants
+	}
+
+	// Outside combat there may be no turn at all, which is -1
+	switch {
+	case ct.IsActive && (ct.CurrentTurnIdx < 0 || ct.CurrentTurnIdx >= len(ct.Combatants)):
+		repaired("currentTurnIdx", "%d is outside the %d combatants, set to 0", ct.CurrentTurnIdx, len(ct.Combatants))
+		ct.CurrentTurnIdx = 0
+	case !ct.IsActive && (ct.CurrentTurnIdx < -1 || (ct.CurrentTurnIdx >= len(ct.Combatants) && ct.CurrentTurnIdx != 0)):
+		repaired("currentTurnIdx", "%d is outside the %d combatants, set to -1", ct.CurrentTurnIdx, len(ct.Combatants))
+		ct.CurrentTurnIdx = -1
+	case ct.CurrentTurnIdx >= 0 && ct.CurrentTurnIdx < len(ct.Combatants) && ct.slotStart(ct.CurrentTurnIdx) != ct.CurrentTurnIdx:
+		start := ct.slotStart(ct.CurrentTurnIdx)
+		repaired("currentTurnIdx", "%d is partway through the %s group's turn, set to %d", ct.CurrentTurnIdx, ct.Combatants[start].Group, start)
+		ct.CurrentTurnIdx = start
+	}
+	return problems
+}
+
+// groupedOrder returns the indices of the combatants in the order they should
+// be in, with each group's members together where its first member is, and the
+// members that had to move to get there
+func groupedOrder(combatants []Combatant) (order, moved []int) {
+	placed := make(map[string]bool)
+	for i, c := range combatants {
+		if c.Group == "" {
+			order = append(order, i)
+			continue
+		}
+		if placed[c.Group] {
+			continue
+		}
+		placed[c.Group] = true
+		for j := i; j < len(combatants); j++ {
+			if combatants[j].Group != c.Group {
+				continue
+			}
+			if j != i && order[len(order)-1] != j-1 {
+				moved = append(moved, j)
+			}
+			order = append(order, j)
+		}
+	}
+	return order, moved
+}
+
+// printSaveProblems reports the problems ValidateAndRepair found in a save
+func printSaveProblems(w io.Writer, filename string, problems []SaveProblem) {
+	if len(problems) == 0 {
+		return
+	}
+
+	repairs := 0
+	for _, p := range problems {
+		if p.Repaired {
+			repairs++
+		}
+	}
+	fmt.Fprintf(w, "Found %d problem(s) in %s, repaired %d:\n", len(problems), filename, repairs)
+	for _, p := range problems {
+		fmt.Fprintf(w, "  %s\n", p)
+	}
+}
+
+// contains reports whether list holds s
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
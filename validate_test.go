@@ -0,0 +1,63 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateTurnIndex(t *testing.T) {
+	tests := []struct {
+		name     string
+		active   bool
+		turn     int
+		want     int
+		problems int
+	}{
+		{"no turn before combat", false, -1, -1, 0},
+		{"turn kept after combat", false, 1, 1, 0},
+		{"past the end after combat", false, 5, -1, 1},
+		{"no turn in combat", true, -1, 0, 1},
+		{"past the end in combat", true, 2, 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ct, _ := newTestTracker(t)
+			ct.AddCombatant("Thorin", 15, 30, true)
+			ct.AddCombatant("Goblin", 12, 7, false)
+			ct.IsActive, ct.Round, ct.CurrentTurnIdx = tt.active, 1, tt.turn
+
+			problems := ct.ValidateAndRepair()
+			if len(problems) != tt.problems {
+				t.Errorf("got problems %v, want %d", problems, tt.problems)
+			}
+			if ct.CurrentTurnIdx != tt.want {
+				t.Errorf("currentTurnIdx is %d, want %d", ct.CurrentTurnIdx, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateGroupsTogether(t *testing.T) {
+	ct, _ := newTestTracker(t)
+	for _, name := range []string{"Goblin", "Thorin", "Goblin2", "Mira"} {
+		ct.Combatants = append(ct.Combatants, Combatant{Name: name, MaxHP: 7, CurrentHP: 7, IsConscious: true, StatusEffects: []string{}})
+	}
+	ct.Combatants[0].Group, ct.Combatants[2].Group = "Goblin", "Goblin"
+	ct.IsActive, ct.Round, ct.CurrentTurnIdx = true, 1, 3 // Mira's turn
+
+	problems := ct.ValidateAndRepair()
+	if len(problems) != 1 || !strings.Contains(problems[0].String(), "Goblin2 is apart from the rest of the Goblin group") {
+		t.Errorf("got problems %v", problems)
+	}
+
+	names := []string{}
+	for _, c := range ct.Combatants {
+		names = append(names, c.Name)
+	}
+	if got := strings.Join(names, ", "); got != "Goblin, Goblin2, Thorin, Mira" {
+		t.Errorf("order is %s", got)
+	}
+	if ct.CurrentTurnIdx != 3 {
+		t.Errorf("currentTurnIdx is %d, want Mira at 3", ct.CurrentTurnIdx)
+	}
+}